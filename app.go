@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -34,20 +39,52 @@ type brightcoveNotifier struct {
 	port            int
 	brightcoveConf  *brightcoveConfig
 	cmsNotifierConf *cmsNotifierConfig
-	client          *http.Client
+	//default client, for the upstreams without a dedicated client in clients
+	client        *http.Client
+	clients       *httpClients
+	history       *publishHistory
+	forwarded     *forwardedContent
+	dryRun        bool
+	failures      *failureStore
+	lifecycle     *lifecycle
+	shutdownConf  shutdownConfig
+	budgets       stageBudgets
+	breakers      map[string]*circuitBreaker
+	breakerConf   breakerConfig
+	tracingConf   tracingConfig
+	stats         *pipelineStats
+	freshnessConf freshnessConfig
+	healthCache   *healthCache
+	//readinessPolicy decides what /__gtg depends on, see readinessPolicySelf and readinessPolicyUpstreams
+	readinessPolicy string
+	options         *configOptions
+	reloader        *reloader
+	sinkConf        sinkConfig
+	subscribers     *webhookDispatcher
+	ingest          *ingestTracker
+	playlists       *playlistCache
+	//imageSets forwards the image set of the videos alongside them
+	imageSets bool
+	//textTracks adds the captions and transcript of the videos from their WebVTT text tracks
+	textTracks bool
+	validation payloadValidation
 }
 
+// brightcoveConfig and cmsNotifierConfig are read through their methods, as they can be swapped on reload.
 type brightcoveConfig struct {
+	sync.RWMutex
 	addr        string
 	accessToken string
 	accountID   string
 
 	//Brightcove OAuth API access token endpoint
-	oauthAddr string
-	auth      string
+	oauthAddr            string
+	auth                 string
+	accessTokenRenewedAt time.Time
 }
 
 type cmsNotifierConfig struct {
+	sync.RWMutex
 	addr       string
 	auth       string
 	hostHeader string
@@ -55,80 +92,641 @@ type cmsNotifierConfig struct {
 
 func main() {
 	app := cli.App("brightcove-notifier", "Gets notified about Brightcove FT video events, creates UPP publish event and posts it to CMS Notifier.")
-	port := app.Int(cli.IntOpt{
+	configFile := app.String(cli.StringOpt{
+		Name:   "config-file",
+		Value:  "",
+		Desc:   "optional YAML or JSON file setting the options below by name; flags and env vars take precedence over it",
+		EnvVar: "CONFIG_FILE",
+	})
+	opts := newConfigOptions(app)
+	port := opts.Int(cli.IntOpt{
 		Name:   "port",
 		Value:  8080,
 		Desc:   "application port",
 		EnvVar: "PORT",
 	})
-	brightcove := app.String(cli.StringOpt{
+	brightcove := opts.String(cli.StringOpt{
 		Name: "brightcove",
 		// https://cms.api.brightcove.com/v1/accounts/:account_id/videos/:video_id
 		Value:  "https://cms.api.brightcove.com/v1/accounts/",
 		Desc:   "brightcove video api address",
 		EnvVar: "BRIGHTCOVE",
 	})
-	brightcoveOAuth := app.String(cli.StringOpt{
+	brightcoveOAuth := opts.String(cli.StringOpt{
 		Name:   "brightcove-oauth",
 		Value:  "https://oauth.brightcove.com/v3/access_token",
 		Desc:   "brightcove oauth api address",
 		EnvVar: "BRIGHTCOVE_OAUTH",
 	})
-	brightcoveAuth := app.String(cli.StringOpt{
+	brightcoveAuth := opts.Secret(cli.StringOpt{
 		Name: "brightcove-auth",
 		// base64encoded value of 'clientId:clientSecret'
 		// e.g. "Basic Y2xpZW50SWQ6Y2xpZW50U2VjcmV0"
 		Value:  "",
-		Desc:   "brightcove oauth api authorization header",
+		Desc:   "brightcove oauth api authorization header, or a reference to it, e.g. file:/run/secrets/brightcove-auth",
 		EnvVar: "BRIGHTCOVE_AUTH",
 	})
-	brightcoveAccID := app.String(cli.StringOpt{
+	brightcoveClientID := opts.String(cli.StringOpt{
+		Name:   "brightcove-client-id",
+		Value:  "",
+		Desc:   "brightcove oauth client id, or a reference to it; with brightcove-client-secret, replaces brightcove-auth",
+		EnvVar: "BRIGHTCOVE_CLIENT_ID",
+	})
+	brightcoveClientSecret := opts.Secret(cli.StringOpt{
+		Name:   "brightcove-client-secret",
+		Value:  "",
+		Desc:   "brightcove oauth client secret, or a reference to it, e.g. file:/run/secrets/brightcove-client-secret",
+		EnvVar: "BRIGHTCOVE_CLIENT_SECRET",
+	})
+	brightcoveAccID := opts.String(cli.StringOpt{
 		Name:   "brightcove-account-id",
 		Value:  "",
 		Desc:   "brightcove account id: the account with the video events this app gets notified",
 		EnvVar: "BRIGHTCOVE_ACCOUNT_ID",
 	})
-	cmsNotifier := app.String(cli.StringOpt{
+	cmsNotifier := opts.String(cli.StringOpt{
 		Name:   "cms-notifier",
 		Value:  "http://localhost:13080",
 		Desc:   "cms notifier address",
 		EnvVar: "CMS_NOTIFIER",
 	})
-	cmsNotifierAuth := app.String(cli.StringOpt{
+	cmsNotifierAuth := opts.Secret(cli.StringOpt{
 		Name:   "cms-notifier-auth",
 		Value:  "",
-		Desc:   "cms notifier authorization header",
+		Desc:   "cms notifier authorization header, or a reference to it, e.g. file:/run/secrets/cms-notifier-auth",
 		EnvVar: "CMS_NOTIFIER_AUTH",
 	})
-	cmsNotifierHostHeader := app.String(cli.StringOpt{
+	secretRefreshInterval := opts.Duration(cli.StringOpt{
+		Name:   "secret-refresh-interval",
+		Value:  "5m",
+		Desc:   "how often the credentials given by reference are read again, to pick up rotated secrets; 0 disables it",
+		EnvVar: "SECRET_REFRESH_INTERVAL",
+	})
+	cmsNotifierHostHeader := opts.String(cli.StringOpt{
 		Name:   "cms-notifier-host-header",
 		Value:  "",
 		Desc:   "cms notifier host header",
 		EnvVar: "CMS_NOTIFIER_HOST_HEADER",
 	})
+	historyMaxEntries := opts.Int(cli.IntOpt{
+		Name:   "history-max-entries",
+		Value:  10000,
+		Desc:   "maximum number of processed events kept in the publish history",
+		EnvVar: "HISTORY_MAX_ENTRIES",
+	})
+	historyMaxAge := opts.Duration(cli.StringOpt{
+		Name:   "history-max-age",
+		Value:  "168h",
+		Desc:   "maximum age of the events kept in the publish history, e.g. 72h",
+		EnvVar: "HISTORY_MAX_AGE",
+	})
+	historyFile := opts.String(cli.StringOpt{
+		Name:   "history-file",
+		Value:  "",
+		Desc:   "file the publish history is persisted in; history is kept in memory only if empty",
+		EnvVar: "HISTORY_FILE",
+	})
+	volatileFields := opts.Strings(cli.StringsOpt{
+		Name:   "volatile-fields",
+		Value:  []string{"updated_at"},
+		Desc:   "comma separated, dot notated video fields ignored when checking whether the content changed since the last forward",
+		EnvVar: "VOLATILE_FIELDS",
+	})
+	dryRun := opts.Bool(cli.BoolOpt{
+		Name:   "dry-run",
+		Value:  false,
+		Desc:   "fetch and transform videos, but return the payloads in the responses instead of forwarding them",
+		EnvVar: "DRY_RUN",
+	})
+	failuresMaxEntries := opts.Int(cli.IntOpt{
+		Name:   "failures-max-entries",
+		Value:  1000,
+		Desc:   "maximum number of failed events kept for replays",
+		EnvVar: "FAILURES_MAX_ENTRIES",
+	})
+	shutdownDelay := opts.Duration(cli.StringOpt{
+		Name:   "shutdown-delay",
+		Value:  "5s",
+		Desc:   "time /__gtg reports unhealthy before the listener is closed on shutdown",
+		EnvVar: "SHUTDOWN_DELAY",
+	})
+	shutdownTimeout := opts.Duration(cli.StringOpt{
+		Name:   "shutdown-timeout",
+		Value:  "30s",
+		Desc:   "maximum time to wait for in-flight notifications to finish on shutdown",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	})
+	fetchBudget := opts.Duration(cli.StringOpt{
+		Name:   "fetch-budget",
+		Value:  "20s",
+		Desc:   "maximum time for fetching a video from the Brightcove API, renewing the access token included",
+		EnvVar: "FETCH_BUDGET",
+	})
+	transformBudget := opts.Duration(cli.StringOpt{
+		Name:   "transform-budget",
+		Value:  "10s",
+		Desc:   "maximum time for transforming a video into the UPP payload",
+		EnvVar: "TRANSFORM_BUDGET",
+	})
+	forwardBudget := opts.Duration(cli.StringOpt{
+		Name:   "forward-budget",
+		Value:  "20s",
+		Desc:   "maximum time for forwarding a video to the CMS Notifier",
+		EnvVar: "FORWARD_BUDGET",
+	})
+	tokenBudget := opts.Duration(cli.StringOpt{
+		Name:   "token-budget",
+		Value:  "10s",
+		Desc:   "maximum time for renewing the Brightcove access token",
+		EnvVar: "TOKEN_BUDGET",
+	})
+	breakerFailureThreshold := opts.Int(cli.IntOpt{
+		Name:   "breaker-failure-threshold",
+		Value:  5,
+		Desc:   "consecutive failures of the Brightcove API or the CMS Notifier opening their circuit breaker",
+		EnvVar: "BREAKER_FAILURE_THRESHOLD",
+	})
+	breakerOpenTimeout := opts.Duration(cli.StringOpt{
+		Name:   "breaker-open-timeout",
+		Value:  "30s",
+		Desc:   "time an open circuit breaker waits before letting a probe request through",
+		EnvVar: "BREAKER_OPEN_TIMEOUT",
+	})
+	breakerPolicy := opts.Enum(cli.StringOpt{
+		Name:   "breaker-open-policy",
+		Value:  breakerPolicyQueue,
+		Desc:   "what happens to notifications while a circuit breaker is open: 'queue' accepts them and replays them once it closes, 'fail-fast' rejects them with 503",
+		EnvVar: "BREAKER_OPEN_POLICY",
+	}, breakerPolicyQueue, breakerPolicyFailFast)
+	tracingExporter := opts.Enum(cli.StringOpt{
+		Name:   "tracing-exporter",
+		Value:  tracingExporterNone,
+		Desc:   "OpenTelemetry span exporter: none, stdout, file or otlp",
+		EnvVar: "TRACING_EXPORTER",
+	}, tracingExporterNone, tracingExporterStdout, tracingExporterFile, tracingExporterOTLP)
+	tracingFile := opts.String(cli.StringOpt{
+		Name:   "tracing-file",
+		Value:  "traces.json",
+		Desc:   "file the spans are written to by the file exporter",
+		EnvVar: "TRACING_FILE",
+	})
+	tracingOTLPEndpoint := opts.String(cli.StringOpt{
+		Name:   "tracing-otlp-endpoint",
+		Value:  "localhost:4318",
+		Desc:   "host:port of the OTLP HTTP collector the otlp exporter sends the spans to",
+		EnvVar: "TRACING_OTLP_ENDPOINT",
+	})
+	healthMaxForwardAge := opts.Duration(cli.StringOpt{
+		Name:   "health-max-forward-age",
+		Value:  "6h",
+		Desc:   "health check fails if no video was forwarded successfully for this long",
+		EnvVar: "HEALTH_MAX_FORWARD_AGE",
+	})
+	healthErrorRatioWindow := opts.Duration(cli.StringOpt{
+		Name:   "health-error-ratio-window",
+		Value:  "1h",
+		Desc:   "time window of the forwards the error ratio health check is computed on",
+		EnvVar: "HEALTH_ERROR_RATIO_WINDOW",
+	})
+	healthMaxErrorRatio := opts.Float(cli.StringOpt{
+		Name:   "health-max-error-ratio",
+		Value:  "0.5",
+		Desc:   "health check fails if the ratio of failed forwards in the window is higher, e.g. 0.5",
+		EnvVar: "HEALTH_MAX_ERROR_RATIO",
+	})
+	healthMinErrorSamples := opts.Int(cli.IntOpt{
+		Name:   "health-min-error-samples",
+		Value:  5,
+		Desc:   "forwards needed in the window for the error ratio health check to fail",
+		EnvVar: "HEALTH_MIN_ERROR_SAMPLES",
+	})
+	healthMaxTokenAge := opts.Duration(cli.StringOpt{
+		Name:   "health-max-token-age",
+		Value:  "15m",
+		Desc:   "health check fails if the Brightcove access token was not renewed for this long",
+		EnvVar: "HEALTH_MAX_TOKEN_AGE",
+	})
+	healthMaxBacklog := opts.Int(cli.IntOpt{
+		Name:   "health-max-backlog",
+		Value:  100,
+		Desc:   "health check fails if more failed events are waiting to be replayed",
+		EnvVar: "HEALTH_MAX_BACKLOG",
+	})
+	healthCheckInterval := opts.Duration(cli.StringOpt{
+		Name:   "health-check-interval",
+		Value:  "30s",
+		Desc:   "how often the Brightcove API and CMS Notifier health checks run in the background",
+		EnvVar: "HEALTH_CHECK_INTERVAL",
+	})
+	healthCheckStaleAfter := opts.Duration(cli.StringOpt{
+		Name:   "health-check-stale-after",
+		Value:  "2m",
+		Desc:   "age after which a background health check result is reported as stale",
+		EnvVar: "HEALTH_CHECK_STALE_AFTER",
+	})
+	readinessPolicy := opts.Enum(cli.StringOpt{
+		Name:   "readiness-policy",
+		Value:  readinessPolicySelf,
+		Desc:   "what /__gtg depends on: 'self' only on this instance accepting and persisting work, 'upstreams' also on the Brightcove API and CMS Notifier being healthy",
+		EnvVar: "READINESS_POLICY",
+	}, readinessPolicySelf, readinessPolicyUpstreams)
+	sinks := opts.Strings(cli.StringsOpt{
+		Name:   "sinks",
+		Value:  []string{sinkCMSNotifier},
+		Desc:   "outputs the UPP payloads are fanned out to: cms-notifier, kafka, webhook, file",
+		EnvVar: "SINKS",
+	})
+	kafkaProxy := opts.String(cli.StringOpt{
+		Name:   "kafka-proxy",
+		Value:  "",
+		Desc:   "Kafka REST proxy address, for the kafka sink",
+		EnvVar: "KAFKA_PROXY",
+	})
+	kafkaTopic := opts.String(cli.StringOpt{
+		Name:   "kafka-topic",
+		Value:  "NativeCmsPublicationEvents",
+		Desc:   "Kafka topic of the kafka sink",
+		EnvVar: "KAFKA_TOPIC",
+	})
+	kafkaRetries := opts.Int(cli.IntOpt{
+		Name:   "kafka-retries",
+		Value:  3,
+		Desc:   "times producing a message to Kafka is retried after failing",
+		EnvVar: "KAFKA_RETRIES",
+	})
+	kafkaRetryBackoff := opts.Duration(cli.StringOpt{
+		Name:   "kafka-retry-backoff",
+		Value:  "500ms",
+		Desc:   "wait before retrying to produce a message to Kafka, doubled at each retry",
+		EnvVar: "KAFKA_RETRY_BACKOFF",
+	})
+	webhookURL := opts.String(cli.StringOpt{
+		Name:   "webhook-url",
+		Value:  "",
+		Desc:   "URL the webhook sink posts the payloads to",
+		EnvVar: "WEBHOOK_URL",
+	})
+	sinkFile := opts.String(cli.StringOpt{
+		Name:   "sink-file",
+		Value:  "-",
+		Desc:   "file the file sink appends the payloads to, - for stdout",
+		EnvVar: "SINK_FILE",
+	})
+	subscribersFile := opts.String(cli.StringOpt{
+		Name:   "subscribers-file",
+		Value:  "",
+		Desc:   "JSON file the webhook subscribers are loaded from and saved to, empty to keep them in memory only",
+		EnvVar: "SUBSCRIBERS_FILE",
+	})
+	subscriberMaxAttempts := opts.Int(cli.IntOpt{
+		Name:   "subscriber-max-attempts",
+		Value:  5,
+		Desc:   "attempts of a delivery to a subscriber before giving up on it",
+		EnvVar: "SUBSCRIBER_MAX_ATTEMPTS",
+	})
+	subscriberRetryBackoff := opts.Duration(cli.StringOpt{
+		Name:   "subscriber-retry-backoff",
+		Value:  "1s",
+		Desc:   "wait before retrying a delivery to a subscriber, doubled at each retry",
+		EnvVar: "SUBSCRIBER_RETRY_BACKOFF",
+	})
+	subscriberQueueSize := opts.Int(cli.IntOpt{
+		Name:   "subscriber-queue-size",
+		Value:  1000,
+		Desc:   "deliveries waiting per subscriber, the next ones are dropped",
+		EnvVar: "SUBSCRIBER_QUEUE_SIZE",
+	})
+	subscriberLogSize := opts.Int(cli.IntOpt{
+		Name:   "subscriber-log-size",
+		Value:  100,
+		Desc:   "delivery attempts kept per subscriber",
+		EnvVar: "SUBSCRIBER_LOG_SIZE",
+	})
+	ingestHoldFirstPublish := opts.Bool(cli.BoolOpt{
+		Name:   "ingest-hold-first-publish",
+		Value:  false,
+		Desc:   "hold the forward of a video still processing in Brightcove until its Dynamic Ingest job succeeded",
+		EnvVar: "INGEST_HOLD_FIRST_PUBLISH",
+	})
+	ingestHoldTimeout := opts.Duration(cli.StringOpt{
+		Name:   "ingest-hold-timeout",
+		Value:  "1h",
+		Desc:   "forward held for ingest after this wait if the outcome of the ingest job wasn't notified",
+		EnvVar: "INGEST_HOLD_TIMEOUT",
+	})
+	ingestJobsMaxEntries := opts.Int(cli.IntOpt{
+		Name:   "ingest-jobs-max-entries",
+		Value:  1000,
+		Desc:   "ingest jobs tracked, the least recently updated ones are forgotten",
+		EnvVar: "INGEST_JOBS_MAX_ENTRIES",
+	})
+	playlists := opts.Strings(cli.StringsOpt{
+		Name:   "playlists",
+		Value:  []string{},
+		Desc:   "ids of the playlists whose membership is added to the payloads, comma separated",
+		EnvVar: "PLAYLISTS",
+	})
+	playlistCacheTTL := opts.Duration(cli.StringOpt{
+		Name:   "playlist-cache-ttl",
+		Value:  "5m",
+		Desc:   "playlists are fetched again after this duration, or as soon as their change is notified",
+		EnvVar: "PLAYLIST_CACHE_TTL",
+	})
+	imageSets := opts.Bool(cli.BoolOpt{
+		Name:   "image-sets",
+		Value:  false,
+		Desc:   "forward the poster and thumbnail of the videos as a companion image set, referenced by mainImage",
+		EnvVar: "IMAGE_SETS",
+	})
+	textTracks := opts.Bool(cli.BoolOpt{
+		Name:   "text-tracks",
+		Value:  false,
+		Desc:   "fetch the WebVTT text tracks of the videos and add their captions and transcript",
+		EnvVar: "TEXT_TRACKS",
+	})
+	payloadValidationMode := opts.Enum(cli.StringOpt{
+		Name:   "payload-validation",
+		Value:  payloadValidationWarn,
+		Desc:   "validation of the payloads against the payload schema: warn about the invalid ones, reject them, or off",
+		EnvVar: "PAYLOAD_VALIDATION",
+	}, payloadValidationOff, payloadValidationWarn, payloadValidationReject)
+	payloadSchemaFile := opts.String(cli.StringOpt{
+		Name:   "payload-schema",
+		Value:  "",
+		Desc:   "JSON Schema file of the payloads, with a version keyword or an $id; empty for the built-in schema",
+		EnvVar: "PAYLOAD_SCHEMA",
+	})
+	clientOptions := make(map[string]clientOpts)
+	for _, upstream := range upstreams {
+		clientOptions[upstream] = newClientOpts(opts, upstream)
+	}
+	opts.reloadable("brightcove", "brightcove-oauth", "brightcove-auth", "brightcove-client-id", "brightcove-client-secret", "brightcove-account-id",
+		"cms-notifier", "cms-notifier-auth", "cms-notifier-host-header")
+	credOpts := credentialOpts{
+		brightcoveAuth:         brightcoveAuth,
+		brightcoveClientID:     brightcoveClientID,
+		brightcoveClientSecret: brightcoveClientSecret,
+		cmsNotifierAuth:        cmsNotifierAuth,
+	}
+	app.Command("config", "config file tools", opts.configCommand)
 
 	app.Action = func() {
+		err := opts.apply(*configFile)
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		historyConf := historyConfig{
+			maxEntries: *historyMaxEntries,
+			file:       *historyFile,
+		}
+		historyConf.maxAge, err = time.ParseDuration(*historyMaxAge)
+		if err != nil {
+			errorLogger.Fatalf("Invalid history-max-age: [%v]", err)
+		}
+		history, err := newPublishHistory(historyConf)
+		if err != nil {
+			errorLogger.Fatalf("Could not load publish history: [%v]", err)
+		}
+		var shutdownConf shutdownConfig
+		shutdownConf.delay, err = time.ParseDuration(*shutdownDelay)
+		if err != nil {
+			errorLogger.Fatalf("Invalid shutdown-delay: [%v]", err)
+		}
+		shutdownConf.timeout, err = time.ParseDuration(*shutdownTimeout)
+		if err != nil {
+			errorLogger.Fatalf("Invalid shutdown-timeout: [%v]", err)
+		}
+		clientConfs, err := clientConfigs(clientOptions)
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		creds, err := credOpts.resolve()
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		sinkConf := sinkConfig{
+			names: *sinks,
+			kafka: kafkaConfig{
+				proxyAddr: *kafkaProxy,
+				topic:     *kafkaTopic,
+				retries:   *kafkaRetries,
+			},
+			webhookURL: *webhookURL,
+			file:       *sinkFile,
+			fileWriter: newLineWriter(*sinkFile),
+		}
+		sinkConf.kafka.retryBackoff, err = time.ParseDuration(*kafkaRetryBackoff)
+		if err != nil {
+			errorLogger.Fatalf("Invalid kafka-retry-backoff: [%v]", err)
+		}
+		err = sinkConf.validate()
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		webhookConf := webhookConfig{
+			file:        *subscribersFile,
+			maxAttempts: *subscriberMaxAttempts,
+			queueSize:   *subscriberQueueSize,
+			logSize:     *subscriberLogSize,
+		}
+		webhookConf.retryBackoff, err = time.ParseDuration(*subscriberRetryBackoff)
+		if err != nil {
+			errorLogger.Fatalf("Invalid subscriber-retry-backoff: [%v]", err)
+		}
+		ingestConf := ingestConfig{
+			holdFirstPublish: *ingestHoldFirstPublish,
+			maxEntries:       *ingestJobsMaxEntries,
+		}
+		ingestConf.holdTimeout, err = time.ParseDuration(*ingestHoldTimeout)
+		if err != nil {
+			errorLogger.Fatalf("Invalid ingest-hold-timeout: [%v]", err)
+		}
+		playlistConf := playlistConfig{ids: *playlists}
+		playlistConf.cacheTTL, err = time.ParseDuration(*playlistCacheTTL)
+		if err != nil {
+			errorLogger.Fatalf("Invalid playlist-cache-ttl: [%v]", err)
+		}
+		validation := payloadValidation{mode: *payloadValidationMode, file: *payloadSchemaFile}
+		validation.schema, err = loadPayloadSchema(*payloadSchemaFile)
+		if err != nil {
+			errorLogger.Fatalf("Could not load payload schema: [%v]", err)
+		}
+		refreshInterval, err := time.ParseDuration(*secretRefreshInterval)
+		if err != nil {
+			errorLogger.Fatalf("Invalid secret-refresh-interval: [%v]", err)
+		}
+		var budgets stageBudgets
+		for _, b := range []struct {
+			name  string
+			value string
+			field *time.Duration
+		}{
+			{"fetch-budget", *fetchBudget, &budgets.fetch},
+			{"transform-budget", *transformBudget, &budgets.transform},
+			{"forward-budget", *forwardBudget, &budgets.forward},
+			{"token-budget", *tokenBudget, &budgets.token},
+		} {
+			*b.field, err = time.ParseDuration(b.value)
+			if err != nil {
+				errorLogger.Fatalf("Invalid %s: [%v]", b.name, err)
+			}
+		}
+		breakerConf := breakerConfig{
+			failureThreshold: *breakerFailureThreshold,
+			policy:           *breakerPolicy,
+		}
+		breakerConf.openTimeout, err = time.ParseDuration(*breakerOpenTimeout)
+		if err != nil {
+			errorLogger.Fatalf("Invalid breaker-open-timeout: [%v]", err)
+		}
+		err = breakerConf.validate()
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		if *readinessPolicy != readinessPolicySelf && *readinessPolicy != readinessPolicyUpstreams {
+			errorLogger.Fatalf("Invalid readiness-policy: [%s]", *readinessPolicy)
+		}
+		tracingConf := tracingConfig{
+			exporter:     *tracingExporter,
+			file:         *tracingFile,
+			otlpEndpoint: *tracingOTLPEndpoint,
+		}
+		stopTracing, err := initTracing(tracingConf)
+		if err != nil {
+			errorLogger.Fatalf("Could not set up tracing: [%v]", err)
+		}
+		freshnessConf := freshnessConfig{
+			minErrorSamples: *healthMinErrorSamples,
+			maxBacklog:      *healthMaxBacklog,
+		}
+		for _, d := range []struct {
+			name  string
+			value string
+			field *time.Duration
+		}{
+			{"health-max-forward-age", *healthMaxForwardAge, &freshnessConf.maxForwardAge},
+			{"health-error-ratio-window", *healthErrorRatioWindow, &freshnessConf.errorRatioWindow},
+			{"health-max-token-age", *healthMaxTokenAge, &freshnessConf.maxTokenAge},
+		} {
+			*d.field, err = time.ParseDuration(d.value)
+			if err != nil {
+				errorLogger.Fatalf("Invalid %s: [%v]", d.name, err)
+			}
+		}
+		var healthCacheConf healthCacheConfig
+		healthCacheConf.interval, err = time.ParseDuration(*healthCheckInterval)
+		if err != nil {
+			errorLogger.Fatalf("Invalid health-check-interval: [%v]", err)
+		}
+		healthCacheConf.staleAfter, err = time.ParseDuration(*healthCheckStaleAfter)
+		if err != nil {
+			errorLogger.Fatalf("Invalid health-check-stale-after: [%v]", err)
+		}
+		err = healthCacheConf.validate()
+		if err != nil {
+			errorLogger.Fatalf("[%v]", err)
+		}
+		freshnessConf.maxErrorRatio, err = strconv.ParseFloat(*healthMaxErrorRatio, 64)
+		if err != nil {
+			errorLogger.Fatalf("Invalid health-max-error-ratio: [%v]", err)
+		}
+		forwarded := newForwardedContent(*volatileFields)
+		forwarded.seed(history)
 		bn := &brightcoveNotifier{
 			port: *port,
 			brightcoveConf: &brightcoveConfig{
 				addr:      *brightcove,
 				oauthAddr: *brightcoveOAuth,
-				auth:      *brightcoveAuth,
+				auth:      creds.brightcoveAuth,
 				accountID: *brightcoveAccID,
 			},
 			cmsNotifierConf: &cmsNotifierConfig{
 				addr:       *cmsNotifier,
-				auth:       *cmsNotifierAuth,
+				auth:       creds.cmsNotifierAuth,
 				hostHeader: *cmsNotifierHostHeader,
 			},
-			client: &http.Client{},
+			clients:         newHTTPClients(clientConfs),
+			history:         history,
+			forwarded:       forwarded,
+			dryRun:          *dryRun,
+			failures:        newFailureStore(*failuresMaxEntries),
+			lifecycle:       newLifecycle(),
+			shutdownConf:    shutdownConf,
+			budgets:         budgets,
+			breakerConf:     breakerConf,
+			tracingConf:     tracingConf,
+			stats:           newPipelineStats(freshnessConf.errorRatioWindow),
+			freshnessConf:   freshnessConf,
+			readinessPolicy: *readinessPolicy,
+			options:         opts,
+			sinkConf:        sinkConf,
+			ingest:          newIngestTracker(ingestConf),
+			playlists:       newPlaylistCache(playlistConf),
+			imageSets:       *imageSets,
+			textTracks:      *textTracks,
+			validation:      validation,
+		}
+		bn.ingest.release = func(h heldPublish, reason string) { bn.releaseHeld(h, reason) }
+		bn.breakers = map[string]*circuitBreaker{
+			upstreamBrightcoveAPI: newCircuitBreaker(upstreamBrightcoveAPI, breakerConf),
+			upstreamCMSNotifier:   newCircuitBreaker(upstreamCMSNotifier, breakerConf),
 		}
+		if breakerConf.policy == breakerPolicyQueue {
+			bn.breakers[upstreamBrightcoveAPI].onClose = func() { bn.replayQueued(stageFetch) }
+			bn.breakers[upstreamCMSNotifier].onClose = func() { bn.replayQueued(stageForward) }
+		}
+		bn.reloader = &reloader{file: *configFile, options: opts, swap: func() error {
+			confs, err := clientConfigs(clientOptions)
+			if err != nil {
+				return err
+			}
+			creds, err := credOpts.resolve()
+			if err != nil {
+				return err
+			}
+			if bn.brightcoveConf.update(*brightcove, *brightcoveOAuth, creds.brightcoveAuth, *brightcoveAccID) {
+				infoLogger.Println("Brightcove credentials changed, the access token will be renewed.")
+			}
+			if bn.cmsNotifierConf.update(*cmsNotifier, creds.cmsNotifierAuth, *cmsNotifierHostHeader) {
+				infoLogger.Println("CMS Notifier credentials changed.")
+			}
+			bn.clients.swap(confs)
+			return nil
+		}}
+		bn.reloader.refreshEvery(refreshInterval)
+		bn.subscribers, err = newWebhookDispatcher(webhookConf, func(req *http.Request) (*http.Response, error) {
+			return bn.do(upstreamSubscribers, req)
+		})
+		if err != nil {
+			errorLogger.Fatalf("Could not load subscribers: [%v]", err)
+		}
+		bn.healthCache = newHealthCache(healthCacheConf, bn.upstreamChecks())
 		infoLogger.Println(bn.prettyPrint())
-		go bn.listen()
-		ch := make(chan os.Signal)
+		bn.healthCache.start()
+		server := &http.Server{
+			Addr:        ":" + strconv.Itoa(bn.port),
+			Handler:     bn.router(),
+			BaseContext: bn.lifecycle.baseContext,
+		}
+		go bn.listen(server)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				infoLogger.Println("Received SIGHUP. Reloading config...")
+				_, _ = bn.reload()
+			}
+		}()
+		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		<-ch
-		infoLogger.Println("Received termination signal. Quitting... \nBye")
+		infoLogger.Println("Received termination signal. Quitting...")
+		bn.shutdown(server)
+		err = stopTracing(context.Background())
+		if err != nil {
+			warnLogger.Printf("Could not flush spans: [%v]", err)
+		}
+		infoLogger.Println("Bye")
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -136,17 +734,36 @@ func main() {
 	}
 }
 
-func (bn brightcoveNotifier) listen() {
+func (bn brightcoveNotifier) router() http.Handler {
 	r := mux.NewRouter()
-	r.HandleFunc("/notify", bn.handleNotification).Methods("POST")
-	r.HandleFunc("/force-notify/{id}", bn.handleForceNotification).Methods("POST")
+	r.HandleFunc("/notify", bn.tracked(bn.handleNotification)).Methods("POST")
+	r.HandleFunc("/force-notify/{id}", bn.tracked(bn.handleForceNotification)).Methods("POST")
+	r.HandleFunc("/ingest-callback", bn.tracked(bn.handleIngestCallback)).Methods("POST")
+	r.HandleFunc("/preview/{id}", bn.handlePreview).Methods("GET")
 	r.HandleFunc("/__health", bn.health()).Methods("GET")
 	r.HandleFunc("/__gtg", bn.gtg).Methods("GET")
+	r.HandleFunc("/__ready", bn.gtg).Methods("GET")
+	r.HandleFunc("/__live", bn.live).Methods("GET")
+	r.HandleFunc("/__history", bn.handleHistory).Methods("GET")
+	r.Handle("/__metrics", expvar.Handler()).Methods("GET")
+	r.HandleFunc("/__failures", bn.handleListFailures).Methods("GET")
+	r.HandleFunc("/__failures/replay", bn.tracked(bn.handleReplayFailures)).Methods("POST")
+	r.HandleFunc("/__failures/{id}/replay", bn.tracked(bn.handleReplayFailure)).Methods("POST")
+	r.HandleFunc("/__reload", bn.handleReload).Methods("POST")
+	r.HandleFunc("/__ingest-jobs", bn.handleListIngestJobs).Methods("GET")
+	r.HandleFunc("/__ingest-jobs/{id}", bn.handleIngestJob).Methods("GET")
+	r.HandleFunc("/__subscribers", bn.handleListSubscribers).Methods("GET")
+	r.HandleFunc("/__subscribers", bn.handlePutSubscriber).Methods("POST")
+	r.HandleFunc("/__subscribers/{id}", bn.handlePutSubscriber).Methods("PUT")
+	r.HandleFunc("/__subscribers/{id}", bn.handleDeleteSubscriber).Methods("DELETE")
+	r.HandleFunc("/__subscribers/{id}/deliveries", bn.handleSubscriberDeliveries).Methods("GET")
+	return r
+}
 
-	http.Handle("/", r)
+func (bn brightcoveNotifier) listen(server *http.Server) {
 	infoLogger.Printf("Starting to listen on port [%d]", bn.port)
-	err := http.ListenAndServe(":"+strconv.Itoa(bn.port), nil)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		errorLogger.Panicf("Couldn't set up HTTP listener: %+v\n", err)
 	}
 }
@@ -156,7 +773,9 @@ type videoEvent struct {
 	AccountID string `json:"account_id"`
 	Event     string `json:"event"`
 	Video     string `json:"video"`
-	Version   int    `json:"version"`
+	//Playlist is the id of the changed playlist, for playlist-change events
+	Playlist string `json:"playlist,omitempty"`
+	Version  int    `json:"version"`
 }
 
 func (ve videoEvent) String() string {
@@ -164,82 +783,104 @@ func (ve videoEvent) String() string {
 		time.Unix(0, ve.TimeStamp*int64(time.Millisecond)).Format(time.RFC3339), ve.AccountID, ve.Event, ve.Video, ve.Version)
 }
 
+// handleForceNotification publishes the video, given by its id or by ref:{reference_id}.
 func (bn brightcoveNotifier) handleForceNotification(w http.ResponseWriter, r *http.Request) {
 	transactionID := transactionidutils.GetTransactionIDFromRequest(r)
-	video, err := bn.fetchVideo(videoEvent{Video: mux.Vars(r)["id"]}, transactionID)
-	if err != nil {
-		warnLogger.Printf("tid=%v video_id=%v Fetching video unsuccessful: %v", transactionID, mux.Vars(r)["id"], err)
-		if err.Error() == "Too many requests. status=429" {
-			w.WriteHeader(429)
+	event := videoEvent{Video: mux.Vars(r)["id"]}
+	entry := newHistoryEntry(transactionID, event)
+	defer bn.history.record(entry)
+	ctx, span := startRequestSpan(r, "handleForceNotification", videoIDAttr(event.Video), accountIDAttr(bn.brightcoveConf.account()))
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	if isReferenceID(event.Video) {
+		var videoID string
+		videoID, err = bn.resolveReferenceID(ctx, event.Video, transactionID)
+		if err != nil {
+			entry.failed(err)
+			warnLogger.Printf("tid=%v video_id=%v Resolving reference id unsuccessful: [%v]", transactionID, event.Video, err)
+			w.WriteHeader(bn.referenceErrorStatus(err))
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if video["error_code"] == "NOT_FOUND" {
-		infoLogger.Printf("tid=%v video_id=%s Video was not found in Brightcove API.", transactionID, video["id"])
-	} else {
-		infoLogger.Printf("tid=%v video_id=%s Fetching video successful.", transactionID, video["id"])
+		infoLogger.Printf("tid=%v video_id=%v Resolved reference id [%s].", transactionID, videoID, event.Video)
+		event.Video, entry.VideoID, entry.Event = videoID, videoID, videoEvent{Video: videoID}
+		span.SetAttributes(videoIDAttr(videoID))
 	}
 
-	err = addUPPRequiredFields(video)
+	opts := publishOptions{force: true, dryRun: bn.isDryRun(r)}
+	video, err := bn.publish(ctx, event, transactionID, opts, entry)
 	if err != nil {
-		warnLogger.Printf("tid=[%v]. [%v]", transactionID, err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(bn.pipelineErrorStatus(err))
 		return
 	}
-	infoLogger.Printf("tid=%v video_id=%v uuid=%v Generated uuid for video.", transactionID, video["id"], video["uuid"])
-	err = bn.fwdVideo(video, transactionID)
-	if err != nil {
-		warnLogger.Printf("tid=%v video_id=%v Forwarding video unsuccessful.", transactionID, err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if opts.dryRun {
+		bn.writeDryRun(w, video, transactionID)
 		return
 	}
-	infoLogger.Printf("tid=%v video_id=%s Forwarding video successful.", transactionID, video["id"])
-	if video["error_code"] == "NOT_FOUND" {
+	if video["error_code"] != nil {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
 func (bn brightcoveNotifier) handleNotification(w http.ResponseWriter, r *http.Request) {
 	transactionID := transactionidutils.GetTransactionIDFromRequest(r)
+	ctx, span := startRequestSpan(r, "handleNotification")
+	var err error
+	defer func() { endSpan(span, err) }()
 
 	var event videoEvent
-	err := json.NewDecoder(r.Body).Decode(&event)
+	err = json.NewDecoder(r.Body).Decode(&event)
+	entry := newHistoryEntry(transactionID, event)
+	defer bn.history.record(entry)
+	span.SetAttributes(videoIDAttr(event.Video), accountIDAttr(event.AccountID))
 	if err != nil {
+		entry.failed(err)
 		warnLogger.Printf("tid=%v Invalid request received: %v", transactionID, err)
 		return
 	}
 
-	if bn.brightcoveConf.accountID != event.AccountID {
+	if bn.brightcoveConf.account() != event.AccountID {
+		entry.failed(fmt.Errorf("Unexpected accountID: [%s]", event.AccountID))
 		warnLogger.Printf("tid=%v account_id=%v Invalid notification event received. Unexpected accountID. Ignoring...", transactionID, event.AccountID)
 		return
 	}
-	infoLogger.Printf("tid=%v video_id=%v Received notification event for video.", transactionID, event.Video)
-
-	video, err := bn.fetchVideo(event, transactionID)
-	if err != nil {
-		warnLogger.Printf("tid=[%v]. Fetching video: [%v]", transactionID, err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if event.Event == playlistChangeEvent {
+		infoLogger.Printf("tid=%v playlist_id=%v Received notification event for playlist.", transactionID, event.Playlist)
+		bn.handlePlaylistChange(ctx, w, event, transactionID, bn.isDryRun(r), entry)
 		return
 	}
-	infoLogger.Printf("tid=%v video_id=%s Fetching video successful.", transactionID, video["id"])
+	infoLogger.Printf("tid=%v video_id=%v Received notification event for video.", transactionID, event.Video)
 
-	err = addUPPRequiredFields(video)
+	opts := publishOptions{dryRun: bn.isDryRun(r)}
+	video, err := bn.publish(ctx, event, transactionID, opts, entry)
 	if err != nil {
-		warnLogger.Printf("tid=%v %v", transactionID, err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(bn.pipelineErrorStatus(err))
 		return
 	}
-	infoLogger.Printf("tid=%v video_id=%v uuid=%v Generated uuid for video.", transactionID, video["id"], video["uuid"])
+	if opts.dryRun {
+		bn.writeDryRun(w, video, transactionID)
+	}
+}
 
-	err = bn.fwdVideo(video, transactionID)
-	if err != nil {
-		warnLogger.Printf("tid=%v video_id=%s Forwarding video unsuccessful: [%v]", transactionID, video["id"], err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// pipelineErrorStatus maps the failed stage of publishing to the response status code.
+// Events failing on an open circuit breaker are accepted if they are queued for replay, rejected if the queue is full.
+func (bn brightcoveNotifier) pipelineErrorStatus(err error) int {
+	pErr, ok := err.(*pipelineError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch {
+	case isCircuitOpen(pErr) && bn.breakerConf.policy == breakerPolicyQueue && !pErr.queueFull:
+		return http.StatusAccepted
+	case isCircuitOpen(pErr):
+		return http.StatusServiceUnavailable
+	case pErr.stage == stageFetch && pErr.err.Error() == "Too many requests. status=429":
+		return http.StatusTooManyRequests
+	case pErr.stage == stageTransform || pErr.stage == stageValidate:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
 	}
-	infoLogger.Printf("tid=%v video_id=%s Forwarding video successful.", transactionID, video["id"])
 }
 
 func addUPPRequiredFields(video video) error {
@@ -256,27 +897,46 @@ func addUPPRequiredFields(video video) error {
 
 type video map[string]interface{}
 
-func (bn brightcoveNotifier) fetchVideo(ve videoEvent, tid string) (video, error) {
-	req, err := http.NewRequest("GET", bn.brightcoveConf.addr+bn.brightcoveConf.accountID+"/videos/"+ve.Video, nil)
+const referenceIDPrefix = "ref:"
+
+// isReferenceID tells if the video is given by its reference id, as ref:{reference_id}, rather than by its id.
+func isReferenceID(id string) bool {
+	return strings.HasPrefix(id, referenceIDPrefix) && len(id) > len(referenceIDPrefix)
+}
+
+// resolveReferenceID returns the id of the video with the reference id, so the uuid is derived from the id whatever the lookup.
+func (bn brightcoveNotifier) resolveReferenceID(ctx context.Context, ref string, tid string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "resolveReferenceID")
+	defer func() { endSpan(span, err) }()
+	var v video
+	err = bn.getFromAPI(ctx, "/videos/"+referenceIDPrefix+url.PathEscape(strings.TrimPrefix(ref, referenceIDPrefix)), tid, &v)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	req.Header.Add("Content-type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+bn.brightcoveConf.accessToken)
-	resp, err := bn.client.Do(req)
+	id, ok := v["id"].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("Invalid content, missing video ID.")
+	}
+	return id, nil
+}
+
+// referenceErrorStatus is 404 for unknown reference ids, as there is no id to forward a delete for.
+func (bn brightcoveNotifier) referenceErrorStatus(err error) int {
+	if sErr, ok := err.(*apiStatusError); ok && sErr.statusCode == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+	return bn.pipelineErrorStatus(&pipelineError{stage: stageFetch, err: err})
+}
+
+func (bn brightcoveNotifier) fetchVideo(ctx context.Context, ve videoEvent, tid string) (_ video, err error) {
+	ctx, span := startSpan(ctx, "fetchVideo", videoIDAttr(ve.Video), accountIDAttr(bn.brightcoveConf.account()))
+	defer func() { endSpan(span, err) }()
+	resp, err := bn.apiGet(ctx, "/videos/"+ve.Video, tid)
 	if err != nil {
 		return nil, err
 	}
 	defer cleanupResp(resp)
 	switch resp.StatusCode {
-	case 401:
-		infoLogger.Printf("tid=[%s]. Renewing access token.", tid)
-		err = bn.renewAccessToken()
-		if err != nil {
-			e := fmt.Errorf("Renewing access token failure: [%v].", err)
-			return nil, e
-		}
-		return bn.fetchVideo(ve, tid)
 	case 404:
 		var notFound []map[string]interface{}
 		err = json.NewDecoder(resp.Body).Decode(&notFound)
@@ -300,38 +960,87 @@ func (bn brightcoveNotifier) fetchVideo(ve videoEvent, tid string) (video, error
 	}
 }
 
-func (bn brightcoveNotifier) fwdVideo(video video, tid string) error {
-	videoJSON, err := json.Marshal(video)
+// apiStatusError is an unexpected status code of the Brightcove CMS API.
+type apiStatusError struct {
+	path       string
+	statusCode int
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("Invalid statusCode received for [%s]: [%d]", e.path, e.statusCode)
+}
+
+// getFromAPI decodes the response of the Brightcove CMS API to the path.
+func (bn brightcoveNotifier) getFromAPI(ctx context.Context, path string, tid string, v interface{}) error {
+	resp, err := bn.apiGet(ctx, path, tid)
 	if err != nil {
 		return err
 	}
-	addr := bn.cmsNotifierConf.addr + "/notify"
-	req, err := http.NewRequest("POST", addr, bytes.NewReader(videoJSON))
+	defer cleanupResp(resp)
+	if resp.StatusCode != http.StatusOK {
+		return &apiStatusError{path, resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// apiGet sends a GET request for the path to the Brightcove CMS API, renewing the access token once if it expired.
+func (bn brightcoveNotifier) apiGet(ctx context.Context, path string, tid string) (*http.Response, error) {
+	for renewed := false; ; renewed = true {
+		addr, authorization := bn.brightcoveConf.apiRequest(path)
+		req, err := http.NewRequestWithContext(ctx, "GET", addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-type", "application/json")
+		req.Header.Add("Authorization", authorization)
+		resp, err := bn.do(upstreamBrightcoveAPI, req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || renewed {
+			return resp, err
+		}
+		cleanupResp(resp)
+		infoLogger.Printf("tid=[%s]. Renewing access token.", tid)
+		if err := bn.renewAccessToken(ctx); err != nil {
+			return nil, fmt.Errorf("Renewing access token failure: [%v].", err)
+		}
+	}
+}
+
+// fwdVideo posts the video to the CMS Notifier and returns its response, with the start of the body.
+func (bn brightcoveNotifier) fwdVideo(ctx context.Context, video video, tid string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "fwdVideo", videoIDAttr(video["id"]), uuidAttr(video["uuid"]))
+	defer func() { endSpan(span, err) }()
+	videoJSON, err := json.Marshal(video)
+	if err != nil {
+		return "", err
+	}
+	addr, auth, hostHeader := bn.cmsNotifierConf.request("/notify")
+	req, err := http.NewRequestWithContext(ctx, "POST", addr, bytes.NewReader(videoJSON))
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Add("Content-type", "application/json")
 	req.Header.Add("X-Origin-System-Id", "brightcove")
 	req.Header.Add("X-Request-Id", tid)
-	if bn.cmsNotifierConf.auth != "" {
-		req.Header.Add("Authorization", bn.cmsNotifierConf.auth)
+	injectTraceContext(ctx, req)
+	if auth != "" {
+		req.Header.Add("Authorization", auth)
 	}
-	if bn.cmsNotifierConf.hostHeader != "" {
-		req.Host = bn.cmsNotifierConf.hostHeader
+	if hostHeader != "" {
+		req.Host = hostHeader
 	}
-	resp, err := bn.client.Do(req)
+	resp, err := bn.do(upstreamCMSNotifier, req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer cleanupResp(resp)
+	body := responseBody(resp)
 	switch resp.StatusCode {
 	case 400:
-		msg, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Status code 400. [%s]", string(msg[:]))
+		return responseSummary(resp, body), fmt.Errorf("Status code 400. [%s]", body)
 	case 200:
-		return nil
+		return responseSummary(resp, body), nil
 	default:
-		return fmt.Errorf("Invalid statusCode received: [%d]", resp.StatusCode)
+		return responseSummary(resp, body), fmt.Errorf("Invalid statusCode received: [%d]", resp.StatusCode)
 	}
 }
 
@@ -343,14 +1052,19 @@ type accessTokenResp struct {
 	Expires     int    `json:"expires_in"`
 }
 
-func (bn brightcoveNotifier) renewAccessToken() (err error) {
-	req, err := http.NewRequest("POST", bn.brightcoveConf.oauthAddr, bytes.NewReader([]byte(tokenRequest)))
+func (bn brightcoveNotifier) renewAccessToken(ctx context.Context) (err error) {
+	ctx, cancel := withBudget(ctx, bn.budgets.token)
+	defer cancel()
+	ctx, span := startSpan(ctx, "renewAccessToken")
+	defer func() { endSpan(span, err) }()
+	oauthAddr, auth := bn.brightcoveConf.oauthRequest()
+	req, err := http.NewRequestWithContext(ctx, "POST", oauthAddr, bytes.NewReader([]byte(tokenRequest)))
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", bn.brightcoveConf.auth)
-	resp, err := bn.client.Do(req)
+	req.Header.Add("Authorization", auth)
+	resp, err := bn.do(upstreamBrightcoveOAuth, req)
 	if err != nil {
 		return err
 	}
@@ -366,7 +1080,7 @@ func (bn brightcoveNotifier) renewAccessToken() (err error) {
 	if accTokenResp.AccessToken == "" {
 		return fmt.Errorf("Empty access token: [%#v]", accTokenResp)
 	}
-	bn.brightcoveConf.accessToken = accTokenResp.AccessToken
+	bn.brightcoveConf.tokenRenewed(accTokenResp.AccessToken)
 	return nil
 }
 
@@ -388,10 +1102,12 @@ func initLogs(infoHandle io.Writer, warnHandle io.Writer, errorHandle io.Writer)
 }
 
 func (bn brightcoveNotifier) prettyPrint() string {
-	return fmt.Sprintf("Config: [\n\tport: [%d]\n\tbrightcoveConf: [%s]\n\tcmsNotifierConf: [%s]\n]", bn.port, bn.brightcoveConf.prettyPrint(), bn.cmsNotifierConf.prettyPrint())
+	return fmt.Sprintf("Config: [\n\tport: [%d]\n\tbrightcoveConf: [%s]\n\tcmsNotifierConf: [%s]\n\tclients: [%s]\n\thistoryConf: [%s]\n\tvolatileFields: %v\n\tdryRun: [%t]\n\tfailuresMaxEntries: [%d]\n\tshutdownConf: [%s]\n\tbudgets: [%s]\n\tbreakerConf: [%s]\n\ttracingConf: [%s]\n\tfreshnessConf: [%s]\n\thealthCacheConf: [%s]\n\treadinessPolicy: [%s]\n\tsinkConf: [%s]\n\twebhookConf: [%s]\n\tingestConf: [%s]\n\tplaylistConf: [%s]\n\timageSets: [%t]\n\ttextTracks: [%t]\n\tvalidation: [%s]\n\toptions: [%s]\n]", bn.port, bn.brightcoveConf.prettyPrint(), bn.cmsNotifierConf.prettyPrint(), bn.clients.prettyPrint(), bn.history.conf.prettyPrint(), bn.forwarded.volatileFields, bn.dryRun, bn.failures.maxEntries, bn.shutdownConf.prettyPrint(), bn.budgets.prettyPrint(), bn.breakerConf.prettyPrint(), bn.tracingConf.prettyPrint(), bn.freshnessConf.prettyPrint(), bn.healthCache.conf.prettyPrint(), bn.readinessPolicy, bn.sinkConf.prettyPrint(), bn.subscribers.conf.prettyPrint(), bn.ingest.conf.prettyPrint(), bn.playlists.conf.prettyPrint(), bn.imageSets, bn.textTracks, bn.validation.prettyPrint(), bn.options.prettyPrint())
 }
 
-func (bc brightcoveConfig) prettyPrint() string {
+func (bc *brightcoveConfig) prettyPrint() string {
+	bc.RLock()
+	defer bc.RUnlock()
 	authSet := "empty"
 	if bc.auth != "" {
 		authSet = "set, not empty"
@@ -403,10 +1119,52 @@ func (bc brightcoveConfig) prettyPrint() string {
 	return fmt.Sprintf("\n\t\taddr: [%s]\n\t\toauthAddr: [%s]\n\t\taccountID: [%s]\n\t\tauth: [%s]\n\t\taccessToken: [%s]\n\t", bc.addr, bc.oauthAddr, bc.accountID, authSet, accessTokenSet)
 }
 
-func (cnc cmsNotifierConfig) prettyPrint() string {
+func (cnc *cmsNotifierConfig) prettyPrint() string {
+	cnc.RLock()
+	defer cnc.RUnlock()
 	authSet := "empty"
 	if cnc.auth != "" {
 		authSet = "set, not empty"
 	}
 	return fmt.Sprintf("\n\t\taddr: [%s]\n\t\thostHeader: [%s]\n\t\tauth: [%s]\n\t", cnc.addr, cnc.hostHeader, authSet)
 }
+
+func (bc *brightcoveConfig) account() string {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.accountID
+}
+
+// apiRequest returns the address of the path in the account and the authorization header of the Brightcove API.
+func (bc *brightcoveConfig) apiRequest(path string) (string, string) {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.addr + bc.accountID + path, "Bearer " + bc.accessToken
+}
+
+// oauthRequest returns the address and the authorization header of the Brightcove OAuth API.
+func (bc *brightcoveConfig) oauthRequest() (string, string) {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.oauthAddr, bc.auth
+}
+
+func (bc *brightcoveConfig) tokenRenewed(accessToken string) {
+	bc.Lock()
+	defer bc.Unlock()
+	bc.accessToken = accessToken
+	bc.accessTokenRenewedAt = time.Now()
+}
+
+func (bc *brightcoveConfig) tokenRenewedAt() time.Time {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.accessTokenRenewedAt
+}
+
+// request returns the address of the path, the authorization and the host headers of the CMS Notifier.
+func (cnc *cmsNotifierConfig) request(path string) (string, string, string) {
+	cnc.RLock()
+	defer cnc.RUnlock()
+	return cnc.addr + path, cnc.auth, cnc.hostHeader
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,9 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
 )
 
 func TestRenewAccessToken_HappyScenario_NewTokenIsSavedOnModel(t *testing.T) {
@@ -27,7 +31,7 @@ func TestRenewAccessToken_HappyScenario_NewTokenIsSavedOnModel(t *testing.T) {
 		client: &http.Client{},
 	}
 
-	err := bn.renewAccessToken()
+	err := bn.renewAccessToken(context.Background())
 	if err != nil {
 		t.Fatalf("[%v]", err)
 	}
@@ -49,7 +53,7 @@ func TestRenewAccessToken_InvalidResponseStatusCode_ErrorIsReturned(t *testing.T
 		},
 		client: &http.Client{},
 	}
-	err := bn.renewAccessToken()
+	err := bn.renewAccessToken(context.Background())
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
@@ -67,7 +71,7 @@ func TestRenewAccessToken_AccessTokenFieldIsMissing_ErrorIsReturned(t *testing.T
 		},
 		client: &http.Client{},
 	}
-	err := bn.renewAccessToken()
+	err := bn.renewAccessToken(context.Background())
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
@@ -85,7 +89,7 @@ func TestRenewAccessToken_AccessTokenFieldIsEmpty_ErrorIsReturned(t *testing.T)
 		},
 		client: &http.Client{},
 	}
-	err := bn.renewAccessToken()
+	err := bn.renewAccessToken(context.Background())
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
@@ -111,7 +115,7 @@ func TestFwdVideo_RequestContainsXOriginSystemHeader(t *testing.T) {
 	}
 
 	video := make(map[string]interface{})
-	err := bn.fwdVideo(video, "tid_test")
+	_, err := bn.fwdVideo(context.Background(), video, "tid_test")
 	if err != nil {
 		t.Fatalf("Expected success. Received: [%v]", err)
 	}
@@ -247,7 +251,7 @@ func TestFetchVideo_404VideoNotFound_VideoIdAndNotFoundMessageIsPresent(t *testi
 		},
 	}
 	videoID := "4020894387001"
-	v, err := bn.fetchVideo(videoEvent{Video: videoID}, "tid_test")
+	v, err := bn.fetchVideo(context.Background(), videoEvent{Video: videoID}, "tid_test")
 	if err != nil {
 		t.Fatalf("Expected success. Received error: [%v]", err)
 	}
@@ -266,7 +270,7 @@ func TestFetchVideo_404ButUnmarshallableResponse_ErrorIsReturned(t *testing.T) {
 	}
 
 	videoID := "4020894387001"
-	_, err := bn.fetchVideo(videoEvent{Video: videoID}, "tid_test")
+	_, err := bn.fetchVideo(context.Background(), videoEvent{Video: videoID}, "tid_test")
 	if err == nil {
 		t.Fatalf("Expected failure")
 	}
@@ -282,7 +286,7 @@ func TestFetchVideo_404ButUnexpectedResponse_ErrorIsReturned(t *testing.T) {
 	}
 
 	videoID := "4020894387001"
-	_, err := bn.fetchVideo(videoEvent{Video: videoID}, "tid_test")
+	_, err := bn.fetchVideo(context.Background(), videoEvent{Video: videoID}, "tid_test")
 	if err == nil {
 		t.Fatalf("Expected failure")
 	}
@@ -356,3 +360,83 @@ func buildTestVideoModel(accID, videoID string) string {
 		    "updated_at": "2015-09-17T17:41:20.782Z"
 		}`, accID, videoID)
 }
+
+func TestHandleForceNotification_VideoDeletedInBrightcove_DeleteForwardedWithNoContent(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	var forwarded []video
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cms-notifier/notify":
+			var v video
+			_ = json.NewDecoder(r.Body).Decode(&v)
+			forwarded = append(forwarded, v)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`[{"error_code":"RESOURCE_NOT_FOUND","message":"The resource you requested does not exist"}]`))
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+	}
+
+	w := httptest.NewRecorder()
+	bn.handleForceNotification(w, mux.SetURLVars(httptest.NewRequest("POST", "/force-notify/"+videoID, nil), map[string]string{"id": videoID}))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for the deleted video. Found: [%d]", w.Code)
+	}
+	if len(forwarded) != 1 || forwarded[0]["error_code"] != "RESOURCE_NOT_FOUND" || forwarded[0]["id"] != videoID {
+		t.Errorf("Expected the delete forwarded. Found: [%v]", forwarded)
+	}
+}
+
+func TestHandleForceNotification_ReferenceID_ResolvedBeforeUUIDDerivation(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	var forwarded []video
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case fmt.Sprintf("/accounts/%s/videos/ref:news%%2F42", accID), fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			var v video
+			_ = json.NewDecoder(r.Body).Decode(&v)
+			forwarded = append(forwarded, v)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`[{"error_code":"RESOURCE_NOT_FOUND"}]`))
+		}
+	}))
+	defer ts.Close()
+	history, err := newPublishHistory(historyConfig{maxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		history:         history,
+	}
+
+	w := httptest.NewRecorder()
+	bn.handleForceNotification(w, mux.SetURLVars(httptest.NewRequest("POST", "/force-notify/ref:news/42", nil), map[string]string{"id": "ref:news/42"}))
+	if w.Code != http.StatusOK || len(forwarded) != 1 {
+		t.Fatalf("Expected the video forwarded. Found: [%d] [%v]", w.Code, forwarded)
+	}
+	if expected := uuid.NewMD5(uuid.UUID{}, []byte(videoID)).String(); forwarded[0]["uuid"] != expected {
+		t.Errorf("Expected uuid derived from the video id [%s]. Found: [%v]", expected, forwarded[0]["uuid"])
+	}
+	if entries := history.find(historyQuery{}); len(entries) != 1 || entries[0].VideoID != videoID {
+		t.Errorf("Expected history entry with the video id. Found: [%+v]", entries)
+	}
+
+	w = httptest.NewRecorder()
+	bn.handleForceNotification(w, mux.SetURLVars(httptest.NewRequest("POST", "/force-notify/ref:unknown", nil), map[string]string{"id": "ref:unknown"}))
+	if w.Code != http.StatusNotFound || len(forwarded) != 1 {
+		t.Errorf("Expected 404 and nothing forwarded for an unknown reference id. Found: [%d] [%d] forwards", w.Code, len(forwarded))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-fthealth"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+
+	//breakerPolicyQueue keeps the events failing on an open breaker and replays them once it closes
+	breakerPolicyQueue = "queue"
+	//breakerPolicyFailFast rejects the events failing on an open breaker, for Brightcove to retry them
+	breakerPolicyFailFast = "fail-fast"
+)
+
+// circuitOpenError is returned without calling the upstream while its breaker is open.
+type circuitOpenError struct {
+	upstream string
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit breaker of [%s] is open.", e.upstream)
+}
+
+type breakerConfig struct {
+	//consecutive failures opening the breaker
+	failureThreshold int
+	//time the breaker stays open before letting a probe request through
+	openTimeout time.Duration
+	policy      string
+}
+
+func (bc breakerConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tfailureThreshold: [%d]\n\t\topenTimeout: [%v]\n\t\tpolicy: [%s]\n\t", bc.failureThreshold, bc.openTimeout, bc.policy)
+}
+
+func (bc breakerConfig) validate() error {
+	if bc.failureThreshold <= 0 {
+		return fmt.Errorf("breaker-failure-threshold must be positive: [%d]", bc.failureThreshold)
+	}
+	if bc.policy != breakerPolicyQueue && bc.policy != breakerPolicyFailFast {
+		return fmt.Errorf("Invalid breaker-open-policy: [%s]", bc.policy)
+	}
+	return nil
+}
+
+// circuitBreaker stops calling an upstream after consecutive failures. Once the open timeout elapsed,
+// a single half-open probe request is let through: its success closes the breaker, its failure opens it again.
+// A nil *circuitBreaker lets everything through.
+type circuitBreaker struct {
+	sync.Mutex
+	upstream string
+	conf     breakerConfig
+	state    string
+	failures int
+	openedAt time.Time
+	//onClose is called, in a new goroutine, when the breaker closes after being open
+	onClose func()
+}
+
+func newCircuitBreaker(upstream string, conf breakerConfig) *circuitBreaker {
+	return &circuitBreaker{upstream: upstream, conf: conf, state: breakerClosed}
+}
+
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.conf.openTimeout {
+			return &circuitOpenError{cb.upstream}
+		}
+		infoLogger.Printf("Circuit breaker of [%s] is half-open, probing.", cb.upstream)
+		cb.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return &circuitOpenError{cb.upstream}
+	default:
+		return nil
+	}
+}
+
+func (cb *circuitBreaker) success() {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.failures = 0
+	if cb.state == breakerClosed {
+		return
+	}
+	infoLogger.Printf("Circuit breaker of [%s] closed.", cb.upstream)
+	cb.state = breakerClosed
+	if cb.onClose != nil {
+		go cb.onClose()
+	}
+}
+
+func (cb *circuitBreaker) failure() {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.failures++
+	if cb.state == breakerHalfOpen || (cb.state == breakerClosed && cb.failures >= cb.conf.failureThreshold) {
+		warnLogger.Printf("Circuit breaker of [%s] opened after [%d] consecutive failures.", cb.upstream, cb.failures)
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// cancelled reopens the breaker if its probe was cancelled by the caller, so the next request probes again.
+func (cb *circuitBreaker) cancelled() {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state == breakerHalfOpen {
+		cb.state = breakerOpen
+	}
+}
+
+func (cb *circuitBreaker) currentState() string {
+	if cb == nil {
+		return breakerClosed
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	return cb.state
+}
+
+// do sends the request to the upstream through its circuit breaker.
+// Transport errors and 5xx responses count as failures, unless the request was cancelled by the caller.
+func (bn brightcoveNotifier) do(upstream string, req *http.Request) (*http.Response, error) {
+	cb := bn.breakers[upstream]
+	err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bn.clientFor(upstream).Do(req)
+	switch {
+	case err != nil && req.Context().Err() == context.Canceled:
+		cb.cancelled()
+	case err != nil || resp.StatusCode >= 500:
+		cb.failure()
+	default:
+		cb.success()
+	}
+	return resp, err
+}
+
+// probe sends the request of a health check to the upstream, bypassing its circuit breaker:
+// health checks neither reset the consecutive failures of the calls made by the pipeline nor act as the half-open probe.
+func (bn brightcoveNotifier) probe(upstream string, req *http.Request) (*http.Response, error) {
+	return bn.clientFor(upstream).Do(req)
+}
+
+// isCircuitOpen tells if the error is due to open circuit breakers only.
+func isCircuitOpen(err error) bool {
+	pErr, ok := err.(*pipelineError)
+	if ok {
+		err = pErr.err
+	}
+	if dErr, ok := err.(*deliveryError); ok {
+		for _, err := range dErr.failed {
+			if !isCircuitOpen(err) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok = err.(*circuitOpenError)
+	return ok
+}
+
+// replayQueued replays the failures of the stage calling the upstream whose breaker just closed.
+func (bn brightcoveNotifier) replayQueued(stage string) {
+	if !bn.lifecycle.accept() {
+		return
+	}
+	defer bn.lifecycle.done()
+	for _, f := range bn.failures.list(time.Time{}, time.Time{}) {
+		if f.Stage != stage {
+			continue
+		}
+		result := bn.replay(bn.lifecycle.context(), f)
+		if isCircuitOpen(result.err) {
+			return
+		}
+	}
+}
+
+func (bn brightcoveNotifier) breakerClosed(upstream string) fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Notifications about newly modified/published videos are not being published to UPP.",
+		Name:             fmt.Sprintf("%s circuit breaker closed", upstream),
+		PanicGuide:       "https://sites.google.com/a/ft.com/technology/systems/dynamic-semantic-publishing/extra-publishing/brightcove-notifier-runbook",
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("Calls to %s failed repeatedly, the circuit breaker is open and no calls are made until it closes.", upstream),
+		Checker: func() error {
+			if state := bn.breakers[upstream].currentState(); state != breakerClosed {
+				return fmt.Errorf("Circuit breaker of [%s] is [%s].", upstream, state)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_FailureThresholdReached_BreakerOpensAndProbesAfterTimeout(t *testing.T) {
+	cb := newCircuitBreaker(upstreamCMSNotifier, breakerConfig{failureThreshold: 2, openTimeout: 50 * time.Millisecond})
+
+	cb.failure()
+	if cb.allow() != nil {
+		t.Fatal("Expected breaker to stay closed below the threshold.")
+	}
+	cb.failure()
+	if _, ok := cb.allow().(*circuitOpenError); !ok {
+		t.Fatal("Expected breaker to open at the threshold.")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if cb.allow() != nil || cb.currentState() != breakerHalfOpen {
+		t.Fatal("Expected a probe to be let through after the open timeout.")
+	}
+	if cb.allow() == nil {
+		t.Fatal("Expected a single probe while half-open.")
+	}
+	cb.success()
+	if cb.currentState() != breakerClosed || cb.allow() != nil {
+		t.Fatal("Expected successful probe to close the breaker.")
+	}
+}
+
+func TestHealthChecks_UpstreamHealthy_BreakerAccountingUnaffected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cms-notifier/notify" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		breakers: map[string]*circuitBreaker{
+			upstreamCMSNotifier: newCircuitBreaker(upstreamCMSNotifier, breakerConfig{failureThreshold: 2, openTimeout: time.Hour}),
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := bn.fwdVideo(context.Background(), video{"id": "4020894387001"}, "tid_test"); err == nil {
+			t.Fatal("Expected forward to fail.")
+		}
+		if err := bn.checkCmsNotifierHealth(); err != nil {
+			t.Fatalf("Expected healthy CMS Notifier. Found: [%v]", err)
+		}
+	}
+	if state := bn.breakers[upstreamCMSNotifier].currentState(); state != breakerOpen {
+		t.Errorf("Expected consecutive forward failures to open the breaker despite the health checks. Found: [%s]", state)
+	}
+	if err := bn.checkCmsNotifierHealth(); err != nil {
+		t.Errorf("Expected health check not blocked by the open breaker. Found: [%v]", err)
+	}
+}
+
+func TestHandleNotification_CMSNotifierBreakerOpen_EventIsQueuedAndReplayedWhenBreakerCloses(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	cmsNotifierDown := true
+	forwarded := make(chan string, 1)
+	bn := &brightcoveNotifier{
+		client:      &http.Client{},
+		failures:    newFailureStore(10),
+		breakerConf: breakerConfig{failureThreshold: 1, openTimeout: time.Hour, policy: breakerPolicyQueue},
+	}
+	bn.breakers = map[string]*circuitBreaker{upstreamCMSNotifier: newCircuitBreaker(upstreamCMSNotifier, bn.breakerConf)}
+	bn.breakers[upstreamCMSNotifier].onClose = func() { bn.replayQueued(stageForward) }
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			if cmsNotifierDown {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			forwarded <- r.Header.Get("X-Request-Id")
+		}
+	}))
+	defer ts.Close()
+	bn.brightcoveConf = &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID}
+	bn.cmsNotifierConf = &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"}
+
+	notify := func(tid string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/notify", strings.NewReader(buildTestVideoEvent(accID, videoID)))
+		req.Header.Set("X-Request-Id", tid)
+		bn.handleNotification(w, req)
+		return w.Code
+	}
+	if status := notify("tid_1"); status != http.StatusInternalServerError {
+		t.Fatalf("Expected forward failure opening the breaker. Received status code: [%d]", status)
+	}
+	if status := notify("tid_2"); status != http.StatusAccepted {
+		t.Fatalf("Expected event to be queued while the breaker is open. Received status code: [%d]", status)
+	}
+	if bn.breakerClosed(upstreamCMSNotifier).Checker() == nil {
+		t.Fatal("Expected the breaker health check to fail while the breaker is open.")
+	}
+
+	cmsNotifierDown = false
+	bn.breakers[upstreamCMSNotifier].success()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-forwarded:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the queued events to be replayed. Replayed: [%d]", i)
+		}
+	}
+}
+
+func TestBreakerConfig_Validate(t *testing.T) {
+	tests := []struct {
+		conf  breakerConfig
+		valid bool
+	}{
+		{breakerConfig{failureThreshold: 5, openTimeout: time.Minute, policy: breakerPolicyQueue}, true},
+		{breakerConfig{failureThreshold: 1, openTimeout: time.Minute, policy: breakerPolicyFailFast}, true},
+		{breakerConfig{failureThreshold: 0, openTimeout: time.Minute, policy: breakerPolicyQueue}, false},
+		{breakerConfig{failureThreshold: -1, openTimeout: time.Minute, policy: breakerPolicyQueue}, false},
+		{breakerConfig{failureThreshold: 5, openTimeout: time.Minute, policy: "drop"}, false},
+	}
+	for _, test := range tests {
+		if err := test.conf.validate(); (err == nil) != test.valid {
+			t.Errorf("Config [%+v]: expected valid [%t]. Found: [%v]", test.conf, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jawher/mow.cli"
+)
+
+const (
+	upstreamBrightcoveAPI   = "brightcove-api"
+	upstreamBrightcoveOAuth = "brightcove-oauth"
+	upstreamCMSNotifier     = "cms-notifier"
+	upstreamKafkaProxy      = "kafka-proxy"
+	upstreamWebhook         = "webhook"
+	upstreamSubscribers     = "subscribers"
+	upstreamTextTracks      = "text-tracks"
+)
+
+var upstreams = []string{upstreamBrightcoveAPI, upstreamBrightcoveOAuth, upstreamCMSNotifier, upstreamKafkaProxy, upstreamWebhook, upstreamSubscribers, upstreamTextTracks}
+
+// clientConfig holds the timeouts and connection pool settings of the HTTP client of one upstream.
+type clientConfig struct {
+	timeout               time.Duration
+	connectTimeout        time.Duration
+	tlsTimeout            time.Duration
+	responseHeaderTimeout time.Duration
+	keepAlive             time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+}
+
+func (cc clientConfig) newClient() *http.Client {
+	return &http.Client{
+		Timeout: cc.timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   cc.connectTimeout,
+				KeepAlive: cc.keepAlive,
+			}).DialContext,
+			TLSHandshakeTimeout:   cc.tlsTimeout,
+			ResponseHeaderTimeout: cc.responseHeaderTimeout,
+			IdleConnTimeout:       cc.idleConnTimeout,
+			MaxIdleConns:          cc.maxIdleConns,
+			MaxIdleConnsPerHost:   cc.maxIdleConns,
+		},
+	}
+}
+
+func (cc clientConfig) prettyPrint() string {
+	return fmt.Sprintf("timeout: [%v], connectTimeout: [%v], tlsTimeout: [%v], responseHeaderTimeout: [%v], keepAlive: [%v], idleConnTimeout: [%v], maxIdleConns: [%d]",
+		cc.timeout, cc.connectTimeout, cc.tlsTimeout, cc.responseHeaderTimeout, cc.keepAlive, cc.idleConnTimeout, cc.maxIdleConns)
+}
+
+// clientOpts are the command line options of the HTTP client of one upstream.
+type clientOpts struct {
+	timeout               *string
+	connectTimeout        *string
+	tlsTimeout            *string
+	responseHeaderTimeout *string
+	keepAlive             *string
+	idleConnTimeout       *string
+	maxIdleConns          *int
+}
+
+// newClientOpts declares the client options of the upstream, e.g. --cms-notifier-timeout or CMS_NOTIFIER_TIMEOUT.
+func newClientOpts(opts *configOptions, upstream string) clientOpts {
+	durationOpt := func(name, value, desc string) *string {
+		return opts.Duration(cli.StringOpt{
+			Name:   upstream + "-" + name,
+			Value:  value,
+			Desc:   upstream + " client " + desc,
+			EnvVar: strings.ToUpper(strings.Replace(upstream+"-"+name, "-", "_", -1)),
+		})
+	}
+	co := clientOpts{
+		timeout:               durationOpt("timeout", "30s", "overall request timeout"),
+		connectTimeout:        durationOpt("connect-timeout", "5s", "connect timeout"),
+		tlsTimeout:            durationOpt("tls-timeout", "5s", "TLS handshake timeout"),
+		responseHeaderTimeout: durationOpt("response-header-timeout", "15s", "timeout for receiving the response headers"),
+		keepAlive:             durationOpt("keep-alive", "30s", "TCP keep-alive period"),
+		idleConnTimeout:       durationOpt("idle-conn-timeout", "90s", "time idle connections are kept in the pool"),
+		maxIdleConns: opts.Int(cli.IntOpt{
+			Name:   upstream + "-max-idle-conns",
+			Value:  20,
+			Desc:   upstream + " client connection pool size",
+			EnvVar: strings.ToUpper(strings.Replace(upstream+"-max-idle-conns", "-", "_", -1)),
+		}),
+	}
+	for _, name := range []string{"timeout", "connect-timeout", "tls-timeout", "response-header-timeout", "keep-alive", "idle-conn-timeout", "max-idle-conns"} {
+		opts.reloadable(upstream + "-" + name)
+	}
+	return co
+}
+
+func (co clientOpts) config() (clientConfig, error) {
+	cc := clientConfig{maxIdleConns: *co.maxIdleConns}
+	durations := []struct {
+		value *string
+		field *time.Duration
+	}{
+		{co.timeout, &cc.timeout},
+		{co.connectTimeout, &cc.connectTimeout},
+		{co.tlsTimeout, &cc.tlsTimeout},
+		{co.responseHeaderTimeout, &cc.responseHeaderTimeout},
+		{co.keepAlive, &cc.keepAlive},
+		{co.idleConnTimeout, &cc.idleConnTimeout},
+	}
+	for _, d := range durations {
+		var err error
+		*d.field, err = time.ParseDuration(*d.value)
+		if err != nil {
+			return cc, err
+		}
+	}
+	return cc, nil
+}
+
+func clientConfigs(options map[string]clientOpts) (map[string]clientConfig, error) {
+	confs := make(map[string]clientConfig)
+	for upstream, opts := range options {
+		conf, err := opts.config()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s client configuration: [%v]", upstream, err)
+		}
+		confs[upstream] = conf
+	}
+	return confs, nil
+}
+
+// httpClients are the HTTP clients of the upstreams, with their configuration.
+type httpClients struct {
+	sync.RWMutex
+	confs   map[string]clientConfig
+	clients map[string]*http.Client
+}
+
+func newHTTPClients(confs map[string]clientConfig) *httpClients {
+	hc := &httpClients{
+		confs:   confs,
+		clients: make(map[string]*http.Client),
+	}
+	for upstream, conf := range confs {
+		hc.clients[upstream] = conf.newClient()
+	}
+	return hc
+}
+
+// swap replaces the clients whose configuration changed. Requests in flight complete on the old clients.
+func (hc *httpClients) swap(confs map[string]clientConfig) {
+	hc.Lock()
+	defer hc.Unlock()
+	for upstream, conf := range confs {
+		old, found := hc.clients[upstream]
+		if found && hc.confs[upstream] == conf {
+			continue
+		}
+		hc.clients[upstream] = conf.newClient()
+		hc.confs[upstream] = conf
+		if found {
+			old.CloseIdleConnections()
+		}
+	}
+}
+
+func (hc *httpClients) client(upstream string) (*http.Client, bool) {
+	hc.RLock()
+	defer hc.RUnlock()
+	c, found := hc.clients[upstream]
+	return c, found
+}
+
+func (hc *httpClients) prettyPrint() string {
+	hc.RLock()
+	defer hc.RUnlock()
+	var s string
+	for _, upstream := range upstreams {
+		if conf, found := hc.confs[upstream]; found {
+			s += fmt.Sprintf("\n\t\t%s: [%s]", upstream, conf.prettyPrint())
+		}
+	}
+	return s + "\n\t"
+}
+
+// clientFor returns the client of the upstream, or the default client if the upstream has no dedicated one.
+func (bn brightcoveNotifier) clientFor(upstream string) *http.Client {
+	if bn.clients != nil {
+		if c, found := bn.clients.client(upstream); found {
+			return c
+		}
+	}
+	if bn.client != nil {
+		return bn.client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFwdVideo_CMSNotifierSlowerThanItsClientTimeout_ErrorIsReturned(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	bn := &brightcoveNotifier{
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL},
+		clients: newHTTPClients(map[string]clientConfig{
+			upstreamCMSNotifier: {timeout: 50 * time.Millisecond},
+		}),
+	}
+	_, err := bn.fwdVideo(context.Background(), video{"id": "4020894387001"}, "tid_test")
+	if err == nil {
+		t.Fatal("Expected timeout error.")
+	}
+}
+
+func TestClientFor_UpstreamWithoutDedicatedClient_DefaultClientIsReturned(t *testing.T) {
+	defaultClient := &http.Client{}
+	bn := &brightcoveNotifier{
+		client: defaultClient,
+		clients: newHTTPClients(map[string]clientConfig{
+			upstreamCMSNotifier: {timeout: time.Second},
+		}),
+	}
+	if bn.clientFor(upstreamBrightcoveAPI) != defaultClient {
+		t.Fatal("Expected the default client for the Brightcove API.")
+	}
+	if c := bn.clientFor(upstreamCMSNotifier); c == defaultClient || c.Timeout != time.Second {
+		t.Fatalf("Expected the dedicated CMS Notifier client. Found: [%#v]", c)
+	}
+}
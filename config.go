@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	"gopkg.in/yaml.v3"
+)
+
+// Types of the options, as named in the JSON schema of the config file.
+const (
+	optionString   = "string"
+	optionDuration = "duration"
+	optionNumber   = "number"
+	optionInteger  = "integer"
+	optionBoolean  = "boolean"
+	optionArray    = "array"
+)
+
+// Sources of the option values, from the highest precedence to the lowest.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// configOption is a command line option that can also be set in the config file, under its name.
+type configOption struct {
+	name   string
+	desc   string
+	envVar string
+	kind   string
+	//allowed values, if restricted
+	enum []string
+	//secret values are redacted when printed
+	secret    bool
+	setByUser *bool
+	//*string, *int, *bool or *[]string, depending on the kind
+	value interface{}
+	//value before the config file was applied, restored when the option is removed from the file
+	initial interface{}
+	source  string
+	//reloadable options change on reload, the others need a restart
+	reloadable bool
+}
+
+// configOptions declares the command line options and fills in the ones set neither by flag nor by env from the config file.
+type configOptions struct {
+	sync.Mutex
+	app     *cli.Cli
+	options []*configOption
+}
+
+func newConfigOptions(app *cli.Cli) *configOptions {
+	return &configOptions{app: app}
+}
+
+func (co *configOptions) add(name, desc, envVar, kind string, setByUser *bool, value interface{}) *configOption {
+	o := &configOption{name: name, desc: desc, envVar: envVar, kind: kind, setByUser: setByUser, value: value, source: sourceDefault}
+	o.initial = o.get()
+	co.options = append(co.options, o)
+	return o
+}
+
+func (co *configOptions) string(opt cli.StringOpt, kind string) (*string, *configOption) {
+	opt.SetByUser = new(bool)
+	value := co.app.String(opt)
+	return value, co.add(opt.Name, opt.Desc, opt.EnvVar, kind, opt.SetByUser, value)
+}
+
+func (co *configOptions) String(opt cli.StringOpt) *string {
+	value, _ := co.string(opt, optionString)
+	return value
+}
+
+// Secret declares a string option whose value is never printed, unless it is a reference to a secret.
+func (co *configOptions) Secret(opt cli.StringOpt) *string {
+	opt.HideValue = true
+	value, o := co.string(opt, optionString)
+	o.secret = true
+	return value
+}
+
+// Duration declares a string option holding a time.Duration, e.g. 30s.
+func (co *configOptions) Duration(opt cli.StringOpt) *string {
+	value, _ := co.string(opt, optionDuration)
+	return value
+}
+
+// Float declares a string option holding a decimal number.
+func (co *configOptions) Float(opt cli.StringOpt) *string {
+	value, _ := co.string(opt, optionNumber)
+	return value
+}
+
+// Enum declares a string option restricted to the given values.
+func (co *configOptions) Enum(opt cli.StringOpt, values ...string) *string {
+	value, o := co.string(opt, optionString)
+	o.enum = values
+	return value
+}
+
+func (co *configOptions) Int(opt cli.IntOpt) *int {
+	opt.SetByUser = new(bool)
+	value := co.app.Int(opt)
+	co.add(opt.Name, opt.Desc, opt.EnvVar, optionInteger, opt.SetByUser, value)
+	return value
+}
+
+func (co *configOptions) Bool(opt cli.BoolOpt) *bool {
+	opt.SetByUser = new(bool)
+	value := co.app.Bool(opt)
+	co.add(opt.Name, opt.Desc, opt.EnvVar, optionBoolean, opt.SetByUser, value)
+	return value
+}
+
+func (co *configOptions) Strings(opt cli.StringsOpt) *[]string {
+	opt.SetByUser = new(bool)
+	value := co.app.Strings(opt)
+	co.add(opt.Name, opt.Desc, opt.EnvVar, optionArray, opt.SetByUser, value)
+	return value
+}
+
+// reloadable marks the options that can change without a restart.
+func (co *configOptions) reloadable(names ...string) {
+	for _, name := range names {
+		if o := co.option(name); o != nil {
+			o.reloadable = true
+		}
+	}
+}
+
+func (co *configOptions) option(name string) *configOption {
+	for _, o := range co.options {
+		if o.name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+// readConfigFile reads the YAML or JSON config file, a map of option names to values.
+func readConfigFile(file string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file [%s]: [%v]", file, err)
+	}
+	return values, nil
+}
+
+// validate checks the values of the config file against the options, returning all the problems found.
+func (co *configOptions) validate(values map[string]interface{}) []error {
+	var errs []error
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := co.option(name)
+		if o == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown option", name))
+			continue
+		}
+		if _, err := o.parse(values[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	return errs
+}
+
+// parse converts a value of the config file to the type of the option value.
+func (o *configOption) parse(v interface{}) (interface{}, error) {
+	switch o.kind {
+	case optionInteger:
+		i, ok := v.(int)
+		if !ok {
+			return nil, fmt.Errorf("expected an integer, found [%v]", v)
+		}
+		return i, nil
+	case optionBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean, found [%v]", v)
+		}
+		return b, nil
+	case optionArray:
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an array of strings, found [%v]", v)
+		}
+		var ss []string
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected an array of strings, found [%v]", item)
+			}
+			ss = append(ss, s)
+		}
+		return ss, nil
+	case optionNumber:
+		switch n := v.(type) {
+		case int, float64:
+			return fmt.Sprint(n), nil
+		case string:
+			if _, err := strconv.ParseFloat(n, 64); err != nil {
+				return nil, fmt.Errorf("expected a number, found [%v]", v)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("expected a number, found [%v]", v)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, found [%v]", v)
+	}
+	if o.kind == optionDuration {
+		if _, err := time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("expected a duration, e.g. 30s, found [%v]", v)
+		}
+	}
+	if len(o.enum) > 0 && !contains(o.enum, s) {
+		return nil, fmt.Errorf("expected one of %v, found [%v]", o.enum, v)
+	}
+	return s, nil
+}
+
+func (o *configOption) get() interface{} {
+	switch value := o.value.(type) {
+	case *string:
+		return *value
+	case *int:
+		return *value
+	case *bool:
+		return *value
+	case *[]string:
+		return append([]string(nil), *value...)
+	}
+	return nil
+}
+
+func (o *configOption) set(v interface{}) {
+	switch value := o.value.(type) {
+	case *string:
+		*value = v.(string)
+	case *int:
+		*value = v.(int)
+	case *bool:
+		*value = v.(bool)
+	case *[]string:
+		*value = v.([]string)
+	}
+}
+
+func (o *configOption) setFromEnv() bool {
+	for _, ev := range strings.Fields(o.envVar) {
+		if os.Getenv(ev) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// optionChange is the change of an option value after a reload. Secret values are redacted.
+type optionChange struct {
+	Option string `json:"option"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	//Applied is false for the options needing a restart to change
+	Applied bool `json:"applied"`
+}
+
+// apply sets the options set neither by flag nor by env from the config file, if any.
+// Precedence is flags > env > file > defaults.
+func (co *configOptions) apply(file string) error {
+	_, err := co.load(file, false)
+	return err
+}
+
+// reload applies the config file again, after validating it, and returns the changes.
+// Only the reloadable options change, the changes of the others are returned as not applied.
+func (co *configOptions) reload(file string) ([]optionChange, error) {
+	return co.load(file, true)
+}
+
+func (co *configOptions) load(file string, reloading bool) ([]optionChange, error) {
+	var values map[string]interface{}
+	if file != "" {
+		var err error
+		values, err = readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if errs := co.validate(values); len(errs) > 0 {
+			return nil, fmt.Errorf("Invalid config file [%s]: %v", file, errs)
+		}
+	}
+	co.Lock()
+	defer co.Unlock()
+	var changes []optionChange
+	for _, o := range co.options {
+		switch {
+		case *o.setByUser:
+			o.source = sourceFlag
+			continue
+		case o.setFromEnv():
+			o.source = sourceEnv
+			continue
+		}
+		v, inFile := values[o.name]
+		value, source := o.initial, sourceDefault
+		if inFile {
+			value, _ = o.parse(v)
+			source = sourceFile
+		}
+		old := o.get()
+		if reloading && !reflect.DeepEqual(old, value) {
+			changes = append(changes, optionChange{Option: o.name, Old: o.format(old), New: o.format(value), Applied: o.reloadable})
+		}
+		if !reloading || o.reloadable {
+			o.set(value)
+			o.source = source
+		}
+	}
+	return changes, nil
+}
+
+// optionValue is the value of an option and where it comes from, saved to roll back a failed reload.
+type optionValue struct {
+	value  interface{}
+	source string
+}
+
+func (co *configOptions) snapshot() map[*configOption]optionValue {
+	co.Lock()
+	defer co.Unlock()
+	saved := make(map[*configOption]optionValue, len(co.options))
+	for _, o := range co.options {
+		saved[o] = optionValue{o.get(), o.source}
+	}
+	return saved
+}
+
+func (co *configOptions) restore(saved map[*configOption]optionValue) {
+	co.Lock()
+	defer co.Unlock()
+	for o, v := range saved {
+		o.set(v.value)
+		o.source = v.source
+	}
+}
+
+func (o *configOption) String() string {
+	return o.format(o.get())
+}
+
+// format formats a value of the option, redacting secrets.
+func (o *configOption) format(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		if o.secret && !isSecretRef(value) {
+			if value == "" {
+				return "empty"
+			}
+			return "set, not empty"
+		}
+		return value
+	case []string:
+		return strings.Join(value, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// prettyPrint lists the effective value of every option with where it comes from, secrets redacted.
+func (co *configOptions) prettyPrint() string {
+	if co == nil {
+		return ""
+	}
+	co.Lock()
+	defer co.Unlock()
+	var s string
+	for _, o := range co.options {
+		s += fmt.Sprintf("\n\t\t%s: [%s] (%s)", o.name, o, o.source)
+	}
+	return s + "\n\t"
+}
+
+// schema returns the JSON schema of the config file.
+func (co *configOptions) schema() ([]byte, error) {
+	properties := make(map[string]interface{})
+	for _, o := range co.options {
+		p := map[string]interface{}{"description": o.desc}
+		switch o.kind {
+		case optionDuration:
+			p["type"] = "string"
+			p["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+		case optionNumber:
+			p["type"] = []string{"number", "string"}
+		case optionArray:
+			p["type"] = "array"
+			p["items"] = map[string]string{"type": "string"}
+		default:
+			p["type"] = o.kind
+		}
+		if len(o.enum) > 0 {
+			p["enum"] = o.enum
+		}
+		properties[o.name] = p
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "brightcove-notifier config file",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}, "", "  ")
+}
+
+// configCommand declares the config subcommands: validate checks a config file, schema prints the schema of config files.
+func (co *configOptions) configCommand(cmd *cli.Cmd) {
+	cmd.Command("validate", "checks a config file against the schema", func(cmd *cli.Cmd) {
+		file := cmd.StringArg("FILE", "", "YAML or JSON config file")
+		cmd.Action = func() {
+			values, err := readConfigFile(*file)
+			if err != nil {
+				errorLogger.Printf("[%v]", err)
+				cli.Exit(1)
+			}
+			errs := co.validate(values)
+			for _, err := range errs {
+				errorLogger.Printf("[%v]", err)
+			}
+			if len(errs) > 0 {
+				cli.Exit(1)
+			}
+			infoLogger.Printf("Config file [%s] is valid.", *file)
+		}
+	})
+	cmd.Command("schema", "prints the JSON schema of config files", func(cmd *cli.Cmd) {
+		cmd.Action = func() {
+			schema, err := co.schema()
+			if err != nil {
+				errorLogger.Fatalf("[%v]", err)
+			}
+			fmt.Println(string(schema))
+		}
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
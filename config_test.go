@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jawher/mow.cli"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConfigOptions_AllSources_FlagsOverEnvOverFileOverDefaults(t *testing.T) {
+	t.Setenv("TEST_ENV", "from-env")
+	file := writeConfigFile(t, "flag: from-file\nenv: from-file\nfile: from-file\nfile-int: 7\n")
+
+	app := cli.App("test", "")
+	opts := newConfigOptions(app)
+	flag := opts.String(cli.StringOpt{Name: "flag", Value: "default", EnvVar: "TEST_FLAG"})
+	env := opts.String(cli.StringOpt{Name: "env", Value: "default", EnvVar: "TEST_ENV"})
+	fromFile := opts.String(cli.StringOpt{Name: "file", Value: "default", EnvVar: "TEST_FILE"})
+	fileInt := opts.Int(cli.IntOpt{Name: "file-int", Value: 1, EnvVar: "TEST_FILE_INT"})
+	def := opts.String(cli.StringOpt{Name: "default", Value: "default", EnvVar: "TEST_DEFAULT"})
+	app.Action = func() {
+		if err := opts.apply(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Run([]string{"test", "--flag", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ found, expected string }{
+		{*flag, "from-flag"},
+		{*env, "from-env"},
+		{*fromFile, "from-file"},
+		{*def, "default"},
+	} {
+		if tc.found != tc.expected {
+			t.Errorf("Expected [%s]. Found: [%s]", tc.expected, tc.found)
+		}
+	}
+	if *fileInt != 7 {
+		t.Errorf("Expected [7]. Found: [%d]", *fileInt)
+	}
+	if source := opts.option("env").source; source != sourceEnv {
+		t.Errorf("Expected env source. Found: [%s]", source)
+	}
+}
+
+func TestConfigOptions_InvalidFile_ReportsEveryProblem(t *testing.T) {
+	app := cli.App("test", "")
+	opts := newConfigOptions(app)
+	opts.Duration(cli.StringOpt{Name: "timeout", Value: "30s"})
+	opts.Enum(cli.StringOpt{Name: "policy", Value: "a"}, "a", "b")
+	opts.Int(cli.IntOpt{Name: "count", Value: 1})
+
+	values, err := readConfigFile(writeConfigFile(t, "timeout: soon\npolicy: c\ncount: many\nunknown: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := opts.validate(values); len(errs) != 4 {
+		t.Errorf("Expected 4 problems. Found: %v", errs)
+	}
+}
+
+func TestConfigOptions_Secret_RedactedInPrettyPrint(t *testing.T) {
+	app := cli.App("test", "")
+	opts := newConfigOptions(app)
+	secret := opts.Secret(cli.StringOpt{Name: "auth", Value: ""})
+	*secret = "Basic Y2xpZW50SWQ6Y2xpZW50U2VjcmV0"
+	if s := opts.prettyPrint(); s != "\n\t\tauth: [set, not empty] (default)\n\t" {
+		t.Errorf("Expected redacted secret. Found: [%s]", s)
+	}
+}
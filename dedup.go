@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// forwardedContent remembers the content hash last forwarded for each uuid,
+// so unchanged videos (e.g. Brightcove touching internal fields only) are not forwarded again.
+// A nil *forwardedContent hashes the whole payload and never reports content as unchanged.
+type forwardedContent struct {
+	sync.RWMutex
+	//dot separated paths of the fields left out of the content hash, e.g. "custom_fields.views"
+	volatileFields []string
+	hashes         map[string]string
+}
+
+func newForwardedContent(volatileFields []string) *forwardedContent {
+	var fields []string
+	for _, f := range volatileFields {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return &forwardedContent{
+		volatileFields: fields,
+		hashes:         make(map[string]string),
+	}
+}
+
+// hash returns the canonical hash of the video, volatile fields excluded.
+// Map keys are sorted by the JSON encoder, so equal content always gives the same hash.
+func (fc *forwardedContent) hash(v video) string {
+	if fc == nil || len(fc.volatileFields) == 0 {
+		return payloadHash(v)
+	}
+	videoJSON, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	var c map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(videoJSON))
+	dec.UseNumber()
+	err = dec.Decode(&c)
+	if err != nil {
+		return ""
+	}
+	for _, f := range fc.volatileFields {
+		removeField(c, strings.Split(f, "."))
+	}
+	return payloadHash(c)
+}
+
+func removeField(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	if nested, ok := m[path[0]].(map[string]interface{}); ok {
+		removeField(nested, path[1:])
+	}
+}
+
+func (fc *forwardedContent) unchanged(uuid, hash string) bool {
+	if fc == nil || hash == "" {
+		return false
+	}
+	fc.RLock()
+	defer fc.RUnlock()
+	return fc.hashes[uuid] == hash
+}
+
+func (fc *forwardedContent) update(uuid, hash string) {
+	if fc == nil || uuid == "" {
+		return
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	fc.hashes[uuid] = hash
+}
+
+// seed restores the last forwarded hashes from the publish history.
+func (fc *forwardedContent) seed(h *publishHistory) {
+	if fc == nil || h == nil {
+		return
+	}
+	h.RLock()
+	defer h.RUnlock()
+	for _, e := range h.entries {
+		if e.ForwardStatus == forwardStatusSuccess {
+			fc.update(e.UUID, e.PayloadHash)
+		}
+	}
+}
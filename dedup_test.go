@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedContentHash_OnlyVolatileFieldsDiffer_HashesAreEqual(t *testing.T) {
+	fc := newForwardedContent([]string{"updated_at", "custom_fields.views"})
+	v1 := video{"id": "1", "updated_at": "2015-09-17T17:41:20.782Z", "custom_fields": map[string]interface{}{"views": 10, "genre": "news"}}
+	v2 := video{"id": "1", "updated_at": "2015-09-18T10:00:00.000Z", "custom_fields": map[string]interface{}{"views": 11, "genre": "news"}}
+
+	if fc.hash(v1) != fc.hash(v2) {
+		t.Fatalf("Expected equal hashes for videos differing in volatile fields only.")
+	}
+	if v1["updated_at"] == nil || v1["custom_fields"].(map[string]interface{})["views"] == nil {
+		t.Fatalf("Expected hashing to leave the video untouched. Found: [%v]", v1)
+	}
+}
+
+func TestForwardedContentHash_ContentDiffers_HashesDiffer(t *testing.T) {
+	fc := newForwardedContent([]string{"updated_at"})
+	v1 := video{"id": "1", "name": "sea_marvels.mp4"}
+	v2 := video{"id": "1", "name": "sea_marvels_v2.mp4"}
+
+	if fc.hash(v1) == fc.hash(v2) {
+		t.Fatalf("Expected different hashes for different content.")
+	}
+}
+
+func TestHandleNotification_Integration_UnchangedContentIsForwardedOnce(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	bn := &brightcoveNotifier{
+		client:    &http.Client{},
+		forwarded: newForwardedContent([]string{"updated_at"}),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notify":
+			bn.handleNotification(w, r)
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			forwards++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	bn.brightcoveConf = &brightcoveConfig{
+		addr:      ts.URL + "/accounts/",
+		accountID: accID,
+	}
+	bn.cmsNotifierConf = &cmsNotifierConfig{
+		addr: ts.URL + "/cms-notifier",
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := http.Post(ts.URL+"/notify", "application/json", bytes.NewReader([]byte(buildTestVideoEvent(accID, videoID))))
+		if err != nil {
+			t.Fatalf("[%v]", err)
+		}
+		if res.StatusCode != 200 {
+			t.Fatalf("Expected success. Received status code: [%d]", res.StatusCode)
+		}
+	}
+	if forwards != 1 {
+		t.Fatalf("Expected unchanged video to be forwarded once. Forwarded: [%d]", forwards)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// dryRunHeader turns on dry-run for a single /notify or /force-notify request.
+const dryRunHeader = "X-Dry-Run"
+
+const forwardStatusDryRun = "dry_run"
+
+// isDryRun tells whether the video should be returned to the caller instead of being forwarded.
+// The header can only turn dry-run on: a notifier started with --dry-run never forwards.
+func (bn brightcoveNotifier) isDryRun(r *http.Request) bool {
+	if bn.dryRun {
+		return true
+	}
+	dryRun, _ := strconv.ParseBool(r.Header.Get(dryRunHeader))
+	return dryRun
+}
+
+// writeDryRun logs and writes in the response the payloads that would have been delivered, in delivery order:
+// the companion payloads, e.g. the image set, then the video.
+func (bn brightcoveNotifier) writeDryRun(w http.ResponseWriter, video video, tid string) {
+	payloadsJSON, err := json.Marshal(bn.payloads(video))
+	if err != nil {
+		warnLogger.Printf("tid=%v video_id=%s Dry-run, could not marshal payloads: [%v]", tid, video["id"], err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	infoLogger.Printf("tid=%v video_id=%s uuid=%v Dry-run, not forwarding payloads: %s", tid, video["id"], video["uuid"], payloadsJSON)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(dryRunHeader, "true")
+	_, err = w.Write(payloadsJSON)
+	if err != nil {
+		warnLogger.Printf("tid=%v Could not write dry-run response: [%v]", tid, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newDryRunTestServer(bn *brightcoveNotifier, accID, videoID string, forwards *int) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notify":
+			bn.handleNotification(w, r)
+		case "/force-notify":
+			bn.handleForceNotification(w, r)
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID), fmt.Sprintf("/accounts/%s/videos/", accID):
+			var v video
+			_ = json.Unmarshal([]byte(buildTestVideoModel(accID, videoID)), &v)
+			v["images"] = buildTestImages("cdn1.example.com")
+			_ = json.NewEncoder(w).Encode(v)
+		case "/cms-notifier/notify":
+			*forwards++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	bn.brightcoveConf = &brightcoveConfig{
+		addr:      ts.URL + "/accounts/",
+		accountID: accID,
+	}
+	bn.cmsNotifierConf = &cmsNotifierConfig{
+		addr: ts.URL + "/cms-notifier",
+	}
+	return ts
+}
+
+func TestHandleNotification_DryRunHeader_PayloadIsReturnedAndNotForwarded(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	bn := &brightcoveNotifier{client: &http.Client{}}
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/notify", strings.NewReader(buildTestVideoEvent(accID, videoID)))
+	req.Header.Set(dryRunHeader, "true")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	defer res.Body.Close()
+
+	var payloads []video
+	err = json.NewDecoder(res.Body).Decode(&payloads)
+	if err != nil {
+		t.Fatalf("Expected the payloads in the response. [%v]", err)
+	}
+	if len(payloads) != 1 || payloads[0]["id"] != videoID || payloads[0]["uuid"] == nil || payloads[0]["type"] != "video" {
+		t.Fatalf("Expected the transformed video in the response. Found: [%v]", payloads)
+	}
+	if forwards != 0 {
+		t.Fatalf("Expected no forwards in dry-run. Forwarded: [%d]", forwards)
+	}
+}
+
+func TestHandleForceNotification_DryRunFlag_HeaderCannotTurnItOff(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	bn := &brightcoveNotifier{client: &http.Client{}, dryRun: true}
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/force-notify", nil)
+	req.Header.Set(dryRunHeader, "false")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get(dryRunHeader) != "true" || forwards != 0 {
+		t.Fatalf("Expected dry-run response without forwards. Forwarded: [%d]", forwards)
+	}
+}
+
+func TestHandleNotification_DryRunWithImageSets_ImageSetReturnedBeforeVideo(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	bn := &brightcoveNotifier{client: &http.Client{}, imageSets: true}
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/notify", strings.NewReader(buildTestVideoEvent(accID, videoID)))
+	req.Header.Set(dryRunHeader, "true")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	defer res.Body.Close()
+
+	var payloads []video
+	err = json.NewDecoder(res.Body).Decode(&payloads)
+	if err != nil {
+		t.Fatalf("Expected the payloads in the response. [%v]", err)
+	}
+	if len(payloads) != 2 || payloads[0]["uuid"] != imageSetUUID(videoID) || payloads[1]["id"] != videoID {
+		t.Fatalf("Expected the image set then the video. Found: [%v]", payloads)
+	}
+	if forwards != 0 {
+		t.Fatalf("Expected no forwards in dry-run. Forwarded: [%d]", forwards)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// failedEvent is a video event that could not be published, kept until a replay succeeds.
+type failedEvent struct {
+	//ID is the transaction ID of the original notification
+	ID     string     `json:"id"`
+	Event  videoEvent `json:"event"`
+	Forced bool       `json:"forced"`
+	Stage  string     `json:"stage"`
+	//Sinks the payload could not be delivered to, the only ones it's delivered to again on replay
+	Sinks []string `json:"sinks,omitempty"`
+	//Violations of the payload schema, if the payload was invalid
+	Violations []schemaViolation `json:"violations,omitempty"`
+	Error      string            `json:"error"`
+	FailedAt   time.Time         `json:"failed_at"`
+	Replays    int               `json:"replays"`
+}
+
+// failureStore keeps the most recent failedEvents, at most maxEntries of them.
+// A nil *failureStore is valid and keeps nothing.
+type failureStore struct {
+	sync.Mutex
+	maxEntries int
+	failures   map[string]*failedEvent
+}
+
+func newFailureStore(maxEntries int) *failureStore {
+	return &failureStore{
+		maxEntries: maxEntries,
+		failures:   make(map[string]*failedEvent),
+	}
+}
+
+// add keeps the failure, evicting the oldest one if the store is full. It returns the evicted failure, if any.
+func (fs *failureStore) add(f *failedEvent) *failedEvent {
+	if fs == nil {
+		return nil
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if existing, found := fs.failures[f.ID]; found {
+		f.Replays = existing.Replays
+	}
+	fs.failures[f.ID] = f
+	if fs.maxEntries > 0 && len(fs.failures) > fs.maxEntries {
+		oldest := fs.sorted(time.Time{}, time.Time{})[0]
+		delete(fs.failures, oldest.ID)
+		return oldest
+	}
+	return nil
+}
+
+// full tells if adding the failure would evict another one.
+func (fs *failureStore) full(id string) bool {
+	if fs == nil {
+		return true
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	_, found := fs.failures[id]
+	return !found && fs.maxEntries > 0 && len(fs.failures) >= fs.maxEntries
+}
+
+func (fs *failureStore) remove(id string) {
+	if fs == nil {
+		return
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	delete(fs.failures, id)
+}
+
+func (fs *failureStore) get(id string) (failedEvent, bool) {
+	if fs == nil {
+		return failedEvent{}, false
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	f, found := fs.failures[id]
+	if !found {
+		return failedEvent{}, false
+	}
+	return *f, true
+}
+
+// list returns copies of the failures in the time range, oldest first. Zero times leave the range open.
+func (fs *failureStore) list(from, to time.Time) []failedEvent {
+	listed := []failedEvent{}
+	if fs == nil {
+		return listed
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	for _, f := range fs.sorted(from, to) {
+		listed = append(listed, *f)
+	}
+	return listed
+}
+
+func (fs *failureStore) size() int {
+	if fs == nil {
+		return 0
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	return len(fs.failures)
+}
+
+func (fs *failureStore) sorted(from, to time.Time) []*failedEvent {
+	var sorted []*failedEvent
+	for _, f := range fs.failures {
+		if (from.IsZero() || !f.FailedAt.Before(from)) && (to.IsZero() || !f.FailedAt.After(to)) {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FailedAt.Before(sorted[j].FailedAt) })
+	return sorted
+}
+
+func (fs *failureStore) replayed(id string) int {
+	if fs == nil {
+		return 0
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	f, found := fs.failures[id]
+	if !found {
+		return 0
+	}
+	f.Replays++
+	return f.Replays
+}
+
+// failed logs the failure of publishing the event and keeps it for replays. Dry-runs are not kept.
+func (bn brightcoveNotifier) failed(event videoEvent, tid string, opts publishOptions, err *pipelineError) error {
+	warnLogger.Printf("tid=%v video_id=%v Publishing video unsuccessful: [%v]", tid, event.Video, err)
+	if opts.dryRun {
+		return err
+	}
+	f := &failedEvent{
+		ID:       originalTransactionID(tid),
+		Event:    event,
+		Forced:   opts.force,
+		Stage:    err.stage,
+		Error:    err.err.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	if dErr, ok := err.err.(*deliveryError); ok {
+		f.Sinks = dErr.sinks()
+	}
+	if vErr, ok := err.err.(*schemaValidationError); ok {
+		f.Violations = vErr.violations
+	}
+	if isCircuitOpen(err) && bn.breakerConf.policy == breakerPolicyQueue && bn.failures.full(f.ID) {
+		warnLogger.Printf("tid=%v video_id=%v Failure store full, rejecting the event instead of queueing it.", tid, event.Video)
+		err.queueFull = true
+		return err
+	}
+	if evicted := bn.failures.add(f); evicted != nil {
+		incMetric(metricFailureEvicted)
+		warnLogger.Printf("tid=%v video_id=%v Failure store full, evicted the oldest failure [%s] of video [%s].", tid, event.Video, evicted.ID, evicted.Event.Video)
+	}
+	return err
+}
+
+type replayResult struct {
+	ID                  string `json:"id"`
+	ReplayTransactionID string `json:"replay_transaction_id"`
+	Success             bool   `json:"success"`
+	Error               string `json:"error,omitempty"`
+	err                 error
+}
+
+// replay publishes the failed event again, with the original transaction ID suffixed by the replay count.
+func (bn brightcoveNotifier) replay(ctx context.Context, f failedEvent) replayResult {
+	tid := fmt.Sprintf("%s%s%d", f.ID, replaySuffix, bn.failures.replayed(f.ID))
+	infoLogger.Printf("tid=%v video_id=%v Replaying failed event.", tid, f.Event.Video)
+	entry := newHistoryEntry(tid, f.Event)
+	defer bn.history.record(entry)
+
+	result := replayResult{ID: f.ID, ReplayTransactionID: tid}
+	_, err := bn.publish(ctx, f.Event, tid, publishOptions{force: f.Forced, sinks: f.Sinks}, entry)
+	if err != nil {
+		result.Error = err.Error()
+		result.err = err
+		return result
+	}
+	bn.failures.remove(f.ID)
+	result.Success = true
+	return result
+}
+
+const replaySuffix = "_replay"
+
+// originalTransactionID strips the replay suffix, so a failing replay updates the original failure.
+func originalTransactionID(tid string) string {
+	if i := strings.LastIndex(tid, replaySuffix); i >= 0 {
+		return tid[:i]
+	}
+	return tid
+}
+
+func (bn brightcoveNotifier) handleListFailures(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, bn.failures.list(q.from, q.to))
+}
+
+func (bn brightcoveNotifier) handleReplayFailure(w http.ResponseWriter, r *http.Request) {
+	f, found := bn.failures.get(mux.Vars(r)["id"])
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	result := bn.replay(r.Context(), f)
+	status := http.StatusOK
+	if !result.Success {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+// handleReplayFailures replays all the failures, or the ones in the time range given by the from and to parameters.
+func (bn brightcoveNotifier) handleReplayFailures(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	results := []replayResult{}
+	for _, f := range bn.failures.list(q.from, q.to) {
+		results = append(results, bn.replay(r.Context(), f))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		warnLogger.Printf("Could not write response: [%v]", err)
+	}
+}
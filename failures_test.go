@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleNotification_ForwardFails_FailureIsKeptAndReplayForwardsIt(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	cmsNotifierDown := true
+	var forwardedTIDs []string
+	bn := &brightcoveNotifier{
+		client:   &http.Client{},
+		failures: newFailureStore(10),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notify":
+			bn.handleNotification(w, r)
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			if cmsNotifierDown {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			forwardedTIDs = append(forwardedTIDs, r.Header.Get("X-Request-Id"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	bn.brightcoveConf = &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID}
+	bn.cmsNotifierConf = &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/notify", strings.NewReader(buildTestVideoEvent(accID, videoID)))
+	req.Header.Set("X-Request-Id", "tid_test")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected failure. Received status code: [%d]", res.StatusCode)
+	}
+	failures := bn.failures.list(time.Time{}, time.Time{})
+	if len(failures) != 1 || failures[0].ID != "tid_test" || failures[0].Stage != stageForward {
+		t.Fatalf("Expected forward failure to be kept. Found: [%#v]", failures)
+	}
+
+	cmsNotifierDown = false
+	w := httptest.NewRecorder()
+	r := mux.NewRouter()
+	r.HandleFunc("/__failures/{id}/replay", bn.handleReplayFailure)
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/__failures/tid_test/replay", nil))
+
+	var result replayResult
+	err = json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	if w.Code != http.StatusOK || !result.Success || result.ReplayTransactionID != "tid_test_replay1" {
+		t.Fatalf("Expected successful replay. Status code: [%d]. Result: [%#v]", w.Code, result)
+	}
+	if len(forwardedTIDs) != 1 || forwardedTIDs[0] != "tid_test_replay1" {
+		t.Fatalf("Expected replay to be forwarded with the replay transaction ID. Forwarded: [%v]", forwardedTIDs)
+	}
+	if len(bn.failures.list(time.Time{}, time.Time{})) != 0 {
+		t.Fatal("Expected failure to be removed after successful replay.")
+	}
+}
+
+func TestFailureStore_ReplayFails_OriginalFailureIsUpdated(t *testing.T) {
+	fs := newFailureStore(10)
+	bn := &brightcoveNotifier{failures: fs}
+	event := videoEvent{Video: "4020894387001"}
+
+	_ = bn.failed(event, "tid_test", publishOptions{}, &pipelineError{stage: stageFetch, err: fmt.Errorf("timeout")})
+	fs.replayed("tid_test")
+	_ = bn.failed(event, "tid_test_replay1", publishOptions{}, &pipelineError{stage: stageForward, err: fmt.Errorf("status 503")})
+
+	failures := fs.list(time.Time{}, time.Time{})
+	if len(failures) != 1 || failures[0].ID != "tid_test" || failures[0].Stage != stageForward || failures[0].Replays != 1 {
+		t.Fatalf("Expected original failure to be updated. Found: [%#v]", failures)
+	}
+}
+
+func TestFailureStore_List_TimeRangeAndMaxEntriesAreApplied(t *testing.T) {
+	fs := newFailureStore(2)
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		fs.add(&failedEvent{ID: fmt.Sprintf("tid_%d", i), FailedAt: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	all := fs.list(time.Time{}, time.Time{})
+	if len(all) != 2 || all[0].ID != "tid_1" || all[1].ID != "tid_2" {
+		t.Fatalf("Expected the two most recent failures. Found: [%#v]", all)
+	}
+	inRange := fs.list(start.Add(90*time.Minute), time.Time{})
+	if len(inRange) != 1 || inRange[0].ID != "tid_2" {
+		t.Fatalf("Expected one failure in range. Found: [%#v]", inRange)
+	}
+}
+
+func TestFailed_QueuePolicyAndStoreFull_EventRejectedAndEvictionsCounted(t *testing.T) {
+	bn := &brightcoveNotifier{failures: newFailureStore(1), breakerConf: breakerConfig{policy: breakerPolicyQueue}}
+	open := func() *pipelineError {
+		return &pipelineError{stage: stageForward, err: &circuitOpenError{upstreamCMSNotifier}}
+	}
+	evicted := func() int64 {
+		if v, ok := pipelineMetrics.Get(metricFailureEvicted).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := evicted()
+
+	if status := bn.pipelineErrorStatus(bn.failed(videoEvent{Video: "1"}, "tid_1", publishOptions{}, open())); status != http.StatusAccepted {
+		t.Fatalf("Expected queued event accepted. Found: [%d]", status)
+	}
+	if status := bn.pipelineErrorStatus(bn.failed(videoEvent{Video: "2"}, "tid_2", publishOptions{}, open())); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected event rejected once the queue is full. Found: [%d]", status)
+	}
+	if _, found := bn.failures.get("tid_1"); !found || bn.failures.size() != 1 {
+		t.Errorf("Expected the queued event kept. Found: [%v]", bn.failures.list(time.Time{}, time.Time{}))
+	}
+
+	_ = bn.failed(videoEvent{Video: "3"}, "tid_3", publishOptions{}, &pipelineError{stage: stageFetch, err: fmt.Errorf("timeout")})
+	if _, found := bn.failures.get("tid_3"); !found || evicted() != before+1 {
+		t.Errorf("Expected other failures to evict the oldest, counted. Found: [%d] evictions", evicted()-before)
+	}
+}
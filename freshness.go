@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-fthealth"
+)
+
+// freshnessConfig holds the thresholds of the health checks driven by the internal state of the pipeline.
+type freshnessConfig struct {
+	maxForwardAge    time.Duration
+	errorRatioWindow time.Duration
+	maxErrorRatio    float64
+	//forwards needed in the window for the error ratio to be meaningful
+	minErrorSamples int
+	maxTokenAge     time.Duration
+	maxBacklog      int
+}
+
+func (fc freshnessConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tmaxForwardAge: [%v]\n\t\terrorRatioWindow: [%v]\n\t\tmaxErrorRatio: [%.2f]\n\t\tminErrorSamples: [%d]\n\t\tmaxTokenAge: [%v]\n\t\tmaxBacklog: [%d]\n\t",
+		fc.maxForwardAge, fc.errorRatioWindow, fc.maxErrorRatio, fc.minErrorSamples, fc.maxTokenAge, fc.maxBacklog)
+}
+
+type forwardOutcome struct {
+	at time.Time
+	ok bool
+}
+
+// pipelineStats keeps the outcomes of the recent forwards. A nil *pipelineStats records nothing.
+type pipelineStats struct {
+	sync.Mutex
+	window      time.Duration
+	startedAt   time.Time
+	lastSuccess time.Time
+	outcomes    []forwardOutcome
+}
+
+func newPipelineStats(window time.Duration) *pipelineStats {
+	return &pipelineStats{window: window, startedAt: time.Now()}
+}
+
+func (ps *pipelineStats) forwarded(err error) {
+	if ps == nil {
+		return
+	}
+	ps.Lock()
+	defer ps.Unlock()
+	now := time.Now()
+	if err == nil {
+		ps.lastSuccess = now
+	}
+	ps.outcomes = append(ps.outcomes, forwardOutcome{now, err == nil})
+	ps.prune(now)
+}
+
+func (ps *pipelineStats) prune(now time.Time) {
+	first := 0
+	for first < len(ps.outcomes) && now.Sub(ps.outcomes[first].at) > ps.window {
+		first++
+	}
+	ps.outcomes = ps.outcomes[first:]
+}
+
+// sinceLastSuccess is the time since the last successful forward, or since start if there was none.
+func (ps *pipelineStats) sinceLastSuccess() time.Duration {
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.lastSuccess.IsZero() {
+		return time.Since(ps.startedAt)
+	}
+	return time.Since(ps.lastSuccess)
+}
+
+func (ps *pipelineStats) errorRatio() (float64, int) {
+	ps.Lock()
+	defer ps.Unlock()
+	ps.prune(time.Now())
+	if len(ps.outcomes) == 0 {
+		return 0, 0
+	}
+	failed := 0
+	for _, o := range ps.outcomes {
+		if !o.ok {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(ps.outcomes)), len(ps.outcomes)
+}
+
+func (bn brightcoveNotifier) freshnessChecks() []fthealth.Check {
+	return []fthealth.Check{bn.forwardsAreRecent(), bn.forwardErrorRatioIsLow(), bn.accessTokenIsFresh(), bn.failureBacklogIsSmall()}
+}
+
+func (bn brightcoveNotifier) forwardsAreRecent() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Newly modified/published videos may not be reaching UPP.",
+		Name:             "Videos forwarded recently",
+		PanicGuide:       "https://sites.google.com/a/ft.com/technology/systems/dynamic-semantic-publishing/extra-publishing/brightcove-notifier-runbook",
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("No video was forwarded successfully to the CMS Notifier in the last %v. Check the Brightcove notification subscriptions and /__history.", bn.freshnessConf.maxForwardAge),
+		Checker: func() error {
+			if bn.stats == nil {
+				return nil
+			}
+			if since := bn.stats.sinceLastSuccess(); since > bn.freshnessConf.maxForwardAge {
+				return fmt.Errorf("Last successful forward was [%v] ago.", since.Truncate(time.Second))
+			}
+			return nil
+		},
+	}
+}
+
+func (bn brightcoveNotifier) forwardErrorRatioIsLow() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Videos are failing to be published to UPP.",
+		Name:             "Forward error ratio",
+		PanicGuide:       "https://sites.google.com/a/ft.com/technology/systems/dynamic-semantic-publishing/extra-publishing/brightcove-notifier-runbook",
+		Severity:         1,
+		TechnicalSummary: fmt.Sprintf("More than %.0f%% of the forwards to the CMS Notifier failed in the last %v. Check /__failures.", bn.freshnessConf.maxErrorRatio*100, bn.freshnessConf.errorRatioWindow),
+		Checker: func() error {
+			if bn.stats == nil {
+				return nil
+			}
+			ratio, total := bn.stats.errorRatio()
+			if total >= bn.freshnessConf.minErrorSamples && ratio > bn.freshnessConf.maxErrorRatio {
+				return fmt.Errorf("[%.0f%%] of the last [%d] forwards failed.", ratio*100, total)
+			}
+			return nil
+		},
+	}
+}
+
+func (bn brightcoveNotifier) accessTokenIsFresh() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Video models of newly modified/published videos may not be fetched.",
+		Name:             "Brightcove access token renewed recently",
+		PanicGuide:       "https://sites.google.com/a/ft.com/technology/systems/dynamic-semantic-publishing/extra-publishing/brightcove-notifier-runbook",
+		Severity:         3,
+		TechnicalSummary: fmt.Sprintf("The Brightcove access token was not renewed in the last %v, although it expires in minutes.", bn.freshnessConf.maxTokenAge),
+		Checker: func() error {
+			renewedAt := bn.brightcoveConf.tokenRenewedAt()
+			if renewedAt.IsZero() {
+				return nil
+			}
+			if age := time.Since(renewedAt); age > bn.freshnessConf.maxTokenAge {
+				return fmt.Errorf("Access token is [%v] old.", age.Truncate(time.Second))
+			}
+			return nil
+		},
+	}
+}
+
+func (bn brightcoveNotifier) failureBacklogIsSmall() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Videos that failed to publish are piling up and not reaching UPP.",
+		Name:             "Failed events backlog",
+		PanicGuide:       "https://sites.google.com/a/ft.com/technology/systems/dynamic-semantic-publishing/extra-publishing/brightcove-notifier-runbook",
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("More than %d failed events are waiting to be replayed. Check /__failures and replay them.", bn.freshnessConf.maxBacklog),
+		Checker: func() error {
+			if backlog := bn.failures.size(); backlog > bn.freshnessConf.maxBacklog {
+				return fmt.Errorf("[%d] failed events are waiting to be replayed.", backlog)
+			}
+			return nil
+		},
+	}
+}
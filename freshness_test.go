@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestForwardErrorRatioIsLow_MostForwardsFailed_CheckFails(t *testing.T) {
+	bn := &brightcoveNotifier{
+		stats:         newPipelineStats(time.Hour),
+		freshnessConf: freshnessConfig{maxErrorRatio: 0.5, minErrorSamples: 3},
+	}
+	bn.stats.forwarded(nil)
+	bn.stats.forwarded(errors.New("status 503"))
+	if err := bn.forwardErrorRatioIsLow().Checker(); err != nil {
+		t.Fatalf("Expected check to pass below the minimum samples. Found: [%v]", err)
+	}
+	bn.stats.forwarded(errors.New("status 503"))
+	if err := bn.forwardErrorRatioIsLow().Checker(); err == nil {
+		t.Fatal("Expected check to fail with 2 out of 3 forwards failed.")
+	}
+}
+
+func TestForwardsAreRecent_NoSuccessfulForwardForTooLong_CheckFails(t *testing.T) {
+	bn := &brightcoveNotifier{
+		stats:         newPipelineStats(time.Hour),
+		freshnessConf: freshnessConfig{maxForwardAge: time.Hour},
+	}
+	if err := bn.forwardsAreRecent().Checker(); err != nil {
+		t.Fatalf("Expected check to pass right after start. Found: [%v]", err)
+	}
+	bn.stats.startedAt = time.Now().Add(-2 * time.Hour)
+	if err := bn.forwardsAreRecent().Checker(); err == nil {
+		t.Fatal("Expected check to fail without successful forwards for 2 hours.")
+	}
+	bn.stats.forwarded(nil)
+	if err := bn.forwardsAreRecent().Checker(); err != nil {
+		t.Fatalf("Expected check to pass after a successful forward. Found: [%v]", err)
+	}
+}
+
+func TestFailureBacklogIsSmall_TooManyFailures_CheckFails(t *testing.T) {
+	bn := &brightcoveNotifier{
+		failures:      newFailureStore(10),
+		freshnessConf: freshnessConfig{maxBacklog: 1},
+	}
+	for i := 0; i < 2; i++ {
+		bn.failures.add(&failedEvent{ID: fmt.Sprintf("tid_%d", i), FailedAt: time.Now()})
+	}
+	if err := bn.failureBacklogIsSmall().Checker(); err == nil {
+		t.Fatal("Expected check to fail with the backlog over the threshold.")
+	}
+}
+
+func TestAccessTokenIsFresh_TokenNotRenewedForTooLong_CheckFails(t *testing.T) {
+	bn := &brightcoveNotifier{
+		brightcoveConf: &brightcoveConfig{accessTokenRenewedAt: time.Now().Add(-time.Hour)},
+		freshnessConf:  freshnessConfig{maxTokenAge: 15 * time.Minute},
+	}
+	if err := bn.accessTokenIsFresh().Checker(); err == nil {
+		t.Fatal("Expected check to fail with an hour old access token.")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-fthealth"
+)
+
+type healthCacheConfig struct {
+	interval time.Duration
+	//results older than this are reported as stale
+	staleAfter time.Duration
+}
+
+func (hcc healthCacheConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tinterval: [%v]\n\t\tstaleAfter: [%v]\n\t", hcc.interval, hcc.staleAfter)
+}
+
+func (hcc healthCacheConfig) validate() error {
+	if hcc.interval <= 0 {
+		return fmt.Errorf("health-check-interval must be positive: [%v]", hcc.interval)
+	}
+	if hcc.staleAfter < hcc.interval {
+		return fmt.Errorf("health-check-stale-after [%v] must be at least health-check-interval [%v]", hcc.staleAfter, hcc.interval)
+	}
+	return nil
+}
+
+type cachedResult struct {
+	err   error
+	ranAt time.Time
+}
+
+// healthCache runs the checks calling the upstreams on a schedule, so /__health and /__gtg
+// serve their last results instantly instead of calling Brightcove and the CMS Notifier on every probe.
+type healthCache struct {
+	sync.RWMutex
+	conf    healthCacheConfig
+	checks  []fthealth.Check
+	results map[string]cachedResult
+	stopCh  chan struct{}
+}
+
+func newHealthCache(conf healthCacheConfig, checks []fthealth.Check) *healthCache {
+	return &healthCache{
+		conf:    conf,
+		checks:  checks,
+		results: make(map[string]cachedResult),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// run runs all the checks in parallel and caches their results.
+func (hc *healthCache) run() {
+	var wg sync.WaitGroup
+	for _, c := range hc.checks {
+		wg.Add(1)
+		go func(c fthealth.Check) {
+			defer wg.Done()
+			err := c.Checker()
+			hc.Lock()
+			defer hc.Unlock()
+			hc.results[c.Name] = cachedResult{err, time.Now()}
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (hc *healthCache) start() {
+	go func() {
+		hc.run()
+		ticker := time.NewTicker(hc.conf.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hc.run()
+			case <-hc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (hc *healthCache) stop() {
+	if hc == nil {
+		return
+	}
+	close(hc.stopCh)
+}
+
+func (hc *healthCache) result(name string) error {
+	hc.RLock()
+	defer hc.RUnlock()
+	res, found := hc.results[name]
+	if !found {
+		return fmt.Errorf("Check has not run yet.")
+	}
+	if age := time.Since(res.ranAt); age > hc.conf.staleAfter {
+		return fmt.Errorf("Check result is stale: last run [%v] ago. Last result: [%v]", age.Truncate(time.Second), res.err)
+	}
+	return res.err
+}
+
+// cached replaces the checker of the check by its last scheduled result. Without a cache, the check runs live.
+func (bn brightcoveNotifier) cached(check fthealth.Check) fthealth.Check {
+	if bn.healthCache == nil {
+		return check
+	}
+	name := check.Name
+	check.Checker = func() error { return bn.healthCache.result(name) }
+	return check
+}
+
+// upstreamChecks are the checks calling the upstreams, run on schedule by the healthCache.
+func (bn brightcoveNotifier) upstreamChecks() []fthealth.Check {
+	return []fthealth.Check{bn.cmsNotifierReachable(), bn.brightcoveAPIReachable(), bn.brightcoveAPIRenewingAccessTokenWorks()}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-fthealth"
+)
+
+func TestCachedCheck_CheckRan_ServesCachedResultWithoutCallingUpstream(t *testing.T) {
+	var calls int32
+	check := fthealth.Check{Name: "upstream", Checker: func() error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("status 503")
+	}}
+	bn := &brightcoveNotifier{healthCache: newHealthCache(healthCacheConfig{interval: time.Hour, staleAfter: time.Hour}, []fthealth.Check{check})}
+	cached := bn.cached(check)
+	if err := cached.Checker(); err == nil {
+		t.Fatal("Expected check to fail before running.")
+	}
+	bn.healthCache.run()
+	for i := 0; i < 3; i++ {
+		if err := cached.Checker(); err == nil || err.Error() != "status 503" {
+			t.Fatalf("Expected cached error. Found: [%v]", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Expected upstream to be called once. Found: [%d]", calls)
+	}
+}
+
+func TestCachedCheck_ResultTooOld_CheckFails(t *testing.T) {
+	check := fthealth.Check{Name: "upstream", Checker: func() error { return nil }}
+	bn := &brightcoveNotifier{healthCache: newHealthCache(healthCacheConfig{interval: time.Hour, staleAfter: time.Minute}, []fthealth.Check{check})}
+	bn.healthCache.run()
+	if err := bn.cached(check).Checker(); err != nil {
+		t.Fatalf("Expected fresh result to pass. Found: [%v]", err)
+	}
+	bn.healthCache.results["upstream"] = cachedResult{ranAt: time.Now().Add(-2 * time.Minute)}
+	if err := bn.cached(check).Checker(); err == nil {
+		t.Fatal("Expected stale result to fail.")
+	}
+}
+
+func TestHealthCache_Started_RefreshesPeriodically(t *testing.T) {
+	var calls int32
+	check := fthealth.Check{Name: "upstream", Checker: func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}}
+	hc := newHealthCache(healthCacheConfig{interval: 10 * time.Millisecond, staleAfter: time.Minute}, []fthealth.Check{check})
+	hc.start()
+	time.Sleep(55 * time.Millisecond)
+	hc.stop()
+	if n := atomic.LoadInt32(&calls); n < 3 {
+		t.Fatalf("Expected check to run at least 3 times. Found: [%d]", n)
+	}
+}
+
+func TestHealthCacheConfig_Validate(t *testing.T) {
+	tests := []struct {
+		conf  healthCacheConfig
+		valid bool
+	}{
+		{healthCacheConfig{interval: 30 * time.Second, staleAfter: 2 * time.Minute}, true},
+		{healthCacheConfig{interval: time.Minute, staleAfter: time.Minute}, true},
+		{healthCacheConfig{interval: 0, staleAfter: time.Minute}, false},
+		{healthCacheConfig{interval: -time.Second, staleAfter: time.Minute}, false},
+		{healthCacheConfig{interval: time.Minute, staleAfter: 30 * time.Second}, false},
+	}
+	for _, test := range tests {
+		if err := test.conf.validate(); (err == nil) != test.valid {
+			t.Errorf("Config [%+v]: expected valid [%t]. Found: [%v]", test.conf, test.valid, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -8,21 +9,57 @@ import (
 )
 
 func (bn brightcoveNotifier) health() func(w http.ResponseWriter, r *http.Request) {
-	return fthealth.HandlerParallel("Dependent services healthcheck", "Checks if all the dependent services are reachable and healthy.",
-		bn.cmsNotifierReachable(), bn.brightcoveAPIReachable(), bn.brightcoveAPIRenewingAccessTokenWorks())
+	var checks []fthealth.Check
+	for _, c := range bn.upstreamChecks() {
+		checks = append(checks, bn.cached(c))
+	}
+	checks = append(checks, bn.breakerClosed(upstreamCMSNotifier), bn.breakerClosed(upstreamBrightcoveAPI))
+	checks = append(checks, bn.freshnessChecks()...)
+	return fthealth.HandlerParallel("Dependent services healthcheck", "Checks if all the dependent services are reachable and healthy.", checks...)
 }
 
-func (bn brightcoveNotifier) gtg(w http.ResponseWriter, r *http.Request) {
-	healthChecks := []func() error{bn.checkCmsNotifierHealth, bn.checkBrightcoveAPIReachable, bn.checkAccessTokenIsValid}
+const (
+	//readinessPolicySelf gates readiness on the ability of the instance to accept and persist work only,
+	//so an upstream outage doesn't take every instance out of the load balancer
+	readinessPolicySelf = "self"
+	//readinessPolicyUpstreams also gates readiness on the reachability of the upstreams and their circuit breakers
+	readinessPolicyUpstreams = "upstreams"
+)
 
-	for _, hCheck := range healthChecks {
-		if err := hCheck(); err != nil {
+// live reports the process is up and serving. It only fails if the process can't serve requests at all.
+func (bn brightcoveNotifier) live(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// gtg reports readiness, i.e. whether the instance should get notifications from the load balancer.
+func (bn brightcoveNotifier) gtg(w http.ResponseWriter, r *http.Request) {
+	for _, check := range bn.readinessChecks() {
+		if err := check(); err != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 	}
 }
 
+func (bn brightcoveNotifier) readinessChecks() []func() error {
+	healthChecks := []func() error{bn.acceptingWork, bn.history.writable}
+	if bn.readinessPolicy != readinessPolicyUpstreams {
+		return healthChecks
+	}
+	for _, c := range bn.upstreamChecks() {
+		healthChecks = append(healthChecks, bn.cached(c).Checker)
+	}
+	return append(healthChecks, bn.breakerClosed(upstreamCMSNotifier).Checker, bn.breakerClosed(upstreamBrightcoveAPI).Checker)
+}
+
+func (bn brightcoveNotifier) acceptingWork() error {
+	if bn.lifecycle.isStopping() {
+		return fmt.Errorf("Shutting down.")
+	}
+	return nil
+}
+
 func (bn brightcoveNotifier) cmsNotifierReachable() fthealth.Check {
 	return fthealth.Check{
 		BusinessImpact:   "Notifications about newly modified/published videos will not reach UPP stack.",
@@ -35,17 +72,18 @@ func (bn brightcoveNotifier) cmsNotifierReachable() fthealth.Check {
 }
 
 func (bn brightcoveNotifier) checkCmsNotifierHealth() error {
-	req, err := http.NewRequest("GET", bn.cmsNotifierConf.addr+"/__health", nil)
+	addr, auth, hostHeader := bn.cmsNotifierConf.request("/__health")
+	req, err := http.NewRequest("GET", addr, nil)
 	if err != nil {
 		return err
 	}
-	if bn.cmsNotifierConf.hostHeader != "" {
-		req.Header.Add("Host", bn.cmsNotifierConf.hostHeader)
+	if hostHeader != "" {
+		req.Header.Add("Host", hostHeader)
 	}
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", bn.cmsNotifierConf.auth)
+	req.Header.Add("Authorization", auth)
 
-	resp, err := bn.client.Do(req)
+	resp, err := bn.probe(upstreamCMSNotifier, req)
 	if err != nil {
 		return err
 	}
@@ -68,13 +106,14 @@ func (bn brightcoveNotifier) brightcoveAPIReachable() fthealth.Check {
 }
 
 func (bn brightcoveNotifier) checkBrightcoveAPIReachable() error {
-	req, err := http.NewRequest("GET", bn.brightcoveConf.addr+bn.brightcoveConf.accountID+"/counts/videos", nil)
+	addr, authorization := bn.brightcoveConf.apiRequest("/counts/videos")
+	req, err := http.NewRequest("GET", addr, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Authorization", "Bearer "+bn.brightcoveConf.accessToken)
+	req.Header.Add("Authorization", authorization)
 
-	resp, err := bn.client.Do(req)
+	resp, err := bn.probe(upstreamBrightcoveAPI, req)
 	if err != nil {
 		return err
 	}
@@ -112,13 +151,14 @@ func (hc brightcoveAPIAccessTokenHealthCheck) checkBrightcoveAPIReturnsValidAcce
 	if hc.calls == 2 {
 		return fmt.Errorf("Video publishing won't work. Access token is not valid.")
 	}
-	req, err := http.NewRequest("GET", hc.bn.brightcoveConf.addr+hc.bn.brightcoveConf.accountID+"/counts/videos", nil)
+	addr, authorization := hc.bn.brightcoveConf.apiRequest("/counts/videos")
+	req, err := http.NewRequest("GET", addr, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Authorization", "Bearer "+hc.bn.brightcoveConf.accessToken)
+	req.Header.Add("Authorization", authorization)
 
-	resp, err := hc.bn.client.Do(req)
+	resp, err := hc.bn.probe(upstreamBrightcoveAPI, req)
 	if err != nil {
 		return err
 	}
@@ -127,7 +167,7 @@ func (hc brightcoveAPIAccessTokenHealthCheck) checkBrightcoveAPIReturnsValidAcce
 	switch resp.StatusCode {
 	case 401:
 		infoLogger.Println("Renewing access token.")
-		err = hc.bn.renewAccessToken()
+		err = hc.bn.renewAccessToken(context.Background())
 		if err != nil {
 			err = fmt.Errorf("Video publishing won't work. Renewing access token failure: [%v].", err)
 			warnLogger.Println(err)
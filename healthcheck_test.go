@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGtg_UpstreamDownWithSelfPolicy_StaysReady(t *testing.T) {
+	cmsNotifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer cmsNotifier.Close()
+	bn := &brightcoveNotifier{
+		cmsNotifierConf: &cmsNotifierConfig{addr: cmsNotifier.URL},
+		brightcoveConf:  &brightcoveConfig{addr: cmsNotifier.URL, oauthAddr: cmsNotifier.URL},
+		client:          &http.Client{},
+		lifecycle:       newLifecycle(),
+		readinessPolicy: readinessPolicySelf,
+	}
+
+	w := httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("GET", "/__gtg", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected ready with the self policy. Received status code: [%d]", w.Code)
+	}
+
+	bn.readinessPolicy = readinessPolicyUpstreams
+	w = httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("GET", "/__gtg", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready with the upstreams policy. Received status code: [%d]", w.Code)
+	}
+}
+
+func TestGtg_HistoryFileNotWritable_NotReady(t *testing.T) {
+	history, err := newPublishHistory(historyConfig{file: filepath.Join(t.TempDir(), "history.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.conf.file = filepath.Join(t.TempDir(), "missing", "history.jsonl")
+	bn := &brightcoveNotifier{history: history, lifecycle: newLifecycle()}
+
+	w := httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("GET", "/__ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready with an unwritable history file. Received status code: [%d]", w.Code)
+	}
+	w = httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("GET", "/__live", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected live. Received status code: [%d]", w.Code)
+	}
+}
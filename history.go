@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	fetchStatusFound    = "found"
+	fetchStatusNotFound = "not_found"
+	fetchStatusFailed   = "failed"
+
+	forwardStatusSuccess   = "success"
+	forwardStatusFailed    = "failed"
+	forwardStatusUnchanged = "skipped_unchanged"
+)
+
+// historyEntry is the record of one video event going through the notifier.
+type historyEntry struct {
+	TransactionID string     `json:"transaction_id"`
+	Event         videoEvent `json:"event"`
+	VideoID       string     `json:"video_id"`
+	UUID          string     `json:"uuid,omitempty"`
+	FetchStatus   string     `json:"fetch_status,omitempty"`
+	PayloadHash   string     `json:"payload_hash,omitempty"`
+	ForwardStatus string     `json:"forward_status,omitempty"`
+	CMSResponse   string     `json:"cms_response,omitempty"`
+	Deliveries    []delivery `json:"deliveries,omitempty"`
+	Warnings      []string   `json:"warnings,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	ReceivedAt    time.Time  `json:"received_at"`
+	DurationMs    int64      `json:"duration_ms"`
+}
+
+func newHistoryEntry(tid string, event videoEvent) *historyEntry {
+	return &historyEntry{
+		TransactionID: tid,
+		Event:         event,
+		VideoID:       event.Video,
+		ReceivedAt:    time.Now().UTC(),
+	}
+}
+
+func (e *historyEntry) fetched(video video, err error) {
+	e.FetchStatus = fetchStatus(video, err)
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+func fetchStatus(video video, err error) string {
+	switch {
+	case err != nil:
+		return fetchStatusFailed
+	case video["error_code"] != nil:
+		return fetchStatusNotFound
+	default:
+		return fetchStatusFound
+	}
+}
+
+func (e *historyEntry) forwarded(video video, hash string, deliveries []delivery, err error) {
+	e.UUID, _ = video["uuid"].(string)
+	e.PayloadHash = hash
+	e.Deliveries = deliveries
+	e.CMSResponse = cmsResponse(deliveries)
+	if err != nil {
+		e.ForwardStatus = forwardStatusFailed
+		e.Error = err.Error()
+		return
+	}
+	e.ForwardStatus = forwardStatusSuccess
+}
+
+// cmsResponse is what the CMS Notifier answered to the delivery, or the error of the delivery if it didn't answer.
+func cmsResponse(deliveries []delivery) string {
+	for _, d := range deliveries {
+		if d.Sink != sinkCMSNotifier {
+			continue
+		}
+		if d.Response == "" {
+			return d.Error
+		}
+		return d.Response
+	}
+	return ""
+}
+
+func (e *historyEntry) skipped(video video, hash string) {
+	e.UUID, _ = video["uuid"].(string)
+	e.PayloadHash = hash
+	e.ForwardStatus = forwardStatusUnchanged
+}
+
+func (e *historyEntry) failed(err error) {
+	e.Error = err.Error()
+}
+
+// payloadHash is the hex encoded sha256 of the JSON representation of the video.
+func payloadHash(video video) string {
+	videoJSON, err := json.Marshal(video)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(videoJSON)
+	return hex.EncodeToString(sum[:])
+}
+
+type historyConfig struct {
+	maxEntries int
+	maxAge     time.Duration
+	//optional JSON lines file the history survives restarts in
+	file string
+}
+
+// publishHistory keeps the most recent historyEntries within the configured retention limits.
+// A nil *publishHistory is valid and records nothing.
+type publishHistory struct {
+	sync.RWMutex
+	conf    historyConfig
+	entries []*historyEntry
+	//number of lines in the history file, used to decide when to compact it
+	fileLines int
+}
+
+func newPublishHistory(conf historyConfig) (*publishHistory, error) {
+	h := &publishHistory{conf: conf}
+	if conf.file == "" {
+		return h, nil
+	}
+	err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	h.prune(time.Now())
+	return h, h.compact()
+}
+
+func (h *publishHistory) record(e *historyEntry) {
+	if h == nil {
+		return
+	}
+	e.DurationMs = int64(time.Since(e.ReceivedAt) / time.Millisecond)
+	h.Lock()
+	defer h.Unlock()
+	h.entries = append(h.entries, e)
+	h.prune(time.Now())
+	if h.conf.file == "" {
+		return
+	}
+	err := h.appendToFile(e)
+	if err != nil {
+		warnLogger.Printf("Could not persist history entry: [%v]", err)
+	}
+	if h.fileLines > 2*len(h.entries) {
+		err = h.compact()
+		if err != nil {
+			warnLogger.Printf("Could not compact history file: [%v]", err)
+		}
+	}
+}
+
+func (h *publishHistory) prune(now time.Time) {
+	first := 0
+	if h.conf.maxEntries > 0 && len(h.entries) > h.conf.maxEntries {
+		first = len(h.entries) - h.conf.maxEntries
+	}
+	if h.conf.maxAge > 0 {
+		for first < len(h.entries) && now.Sub(h.entries[first].ReceivedAt) > h.conf.maxAge {
+			first++
+		}
+	}
+	if first > 0 {
+		h.entries = append([]*historyEntry(nil), h.entries[first:]...)
+	}
+}
+
+type historyQuery struct {
+	videoID       string
+	uuid          string
+	transactionID string
+	from          time.Time
+	to            time.Time
+}
+
+func (q historyQuery) matches(e *historyEntry) bool {
+	if q.videoID != "" && q.videoID != e.VideoID {
+		return false
+	}
+	if q.uuid != "" && q.uuid != e.UUID {
+		return false
+	}
+	if q.transactionID != "" && q.transactionID != e.TransactionID {
+		return false
+	}
+	if !q.from.IsZero() && e.ReceivedAt.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && e.ReceivedAt.After(q.to) {
+		return false
+	}
+	return true
+}
+
+// find returns the matching entries, most recent first.
+func (h *publishHistory) find(q historyQuery) []*historyEntry {
+	found := []*historyEntry{}
+	if h == nil {
+		return found
+	}
+	h.RLock()
+	defer h.RUnlock()
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if q.matches(h.entries[i]) {
+			found = append(found, h.entries[i])
+		}
+	}
+	return found
+}
+
+func (h *publishHistory) load() error {
+	f, err := os.Open(h.conf.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		var e historyEntry
+		err = json.Unmarshal(s.Bytes(), &e)
+		if err != nil {
+			warnLogger.Printf("Skipping invalid history entry: [%v]", err)
+			continue
+		}
+		h.entries = append(h.entries, &e)
+	}
+	return s.Err()
+}
+
+func (h *publishHistory) appendToFile(e *historyEntry) error {
+	f, err := os.OpenFile(h.conf.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(e)
+	if err != nil {
+		return err
+	}
+	h.fileLines++
+	return nil
+}
+
+// writable checks the history file can still be appended to. The history is always writable without a file.
+func (h *publishHistory) writable() error {
+	if h == nil || h.conf.file == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.conf.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("History file is not writable: [%v]", err)
+	}
+	return f.Close()
+}
+
+// compact rewrites the history file with the retained entries only.
+func (h *publishHistory) compact() error {
+	tmp := h.conf.file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range h.entries {
+		err = enc.Encode(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	h.fileLines = len(h.entries)
+	return os.Rename(tmp, h.conf.file)
+}
+
+func (hc historyConfig) prettyPrint() string {
+	file := "in-memory only"
+	if hc.file != "" {
+		file = hc.file
+	}
+	return fmt.Sprintf("\n\t\tmaxEntries: [%d]\n\t\tmaxAge: [%v]\n\t\tfile: [%s]\n\t", hc.maxEntries, hc.maxAge, file)
+}
+
+func (bn brightcoveNotifier) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, bn.history.find(q))
+}
+
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
+	params := r.URL.Query()
+	q := historyQuery{
+		videoID:       params.Get("videoID"),
+		uuid:          params.Get("uuid"),
+		transactionID: params.Get("transactionID"),
+	}
+	var err error
+	if from := params.Get("from"); from != "" {
+		q.from, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'from' parameter, expected RFC3339 time: [%v]", err)
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		q.to, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'to' parameter, expected RFC3339 time: [%v]", err)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishHistory_MaxEntriesExceeded_OldestEntriesAreDropped(t *testing.T) {
+	h, err := newPublishHistory(historyConfig{maxEntries: 2})
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		h.record(newHistoryEntry("tid_"+id, videoEvent{Video: id}))
+	}
+
+	found := h.find(historyQuery{})
+	if len(found) != 2 || found[0].VideoID != "3" || found[1].VideoID != "2" {
+		t.Fatalf("Expected the two most recent entries. Found: [%#v]", found)
+	}
+}
+
+func TestPublishHistory_MaxAgeExceeded_ExpiredEntriesAreDropped(t *testing.T) {
+	h, err := newPublishHistory(historyConfig{maxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	expired := newHistoryEntry("tid_1", videoEvent{Video: "1"})
+	expired.ReceivedAt = time.Now().Add(-2 * time.Hour)
+	h.record(expired)
+	h.record(newHistoryEntry("tid_2", videoEvent{Video: "2"}))
+
+	found := h.find(historyQuery{})
+	if len(found) != 1 || found[0].VideoID != "2" {
+		t.Fatalf("Expected only the recent entry. Found: [%#v]", found)
+	}
+}
+
+func TestPublishHistory_Find_FiltersAreApplied(t *testing.T) {
+	h, err := newPublishHistory(historyConfig{})
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	old := newHistoryEntry("tid_1", videoEvent{Video: "1"})
+	old.ReceivedAt = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.record(old)
+	forwarded := newHistoryEntry("tid_2", videoEvent{Video: "2"})
+	forwarded.forwarded(video{"id": "2", "uuid": "e8a5bbd1-5ba4-3b2f-8a74-c8ec8eb1c3fc"}, "", nil, nil)
+	h.record(forwarded)
+
+	tests := []struct {
+		q        historyQuery
+		expected string
+	}{
+		{historyQuery{videoID: "1"}, "tid_1"},
+		{historyQuery{uuid: "e8a5bbd1-5ba4-3b2f-8a74-c8ec8eb1c3fc"}, "tid_2"},
+		{historyQuery{transactionID: "tid_2"}, "tid_2"},
+		{historyQuery{to: time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)}, "tid_1"},
+		{historyQuery{from: time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)}, "tid_2"},
+	}
+	for _, test := range tests {
+		found := h.find(test.q)
+		if len(found) != 1 || found[0].TransactionID != test.expected {
+			t.Errorf("Query [%#v]: expected [%s]. Found: [%#v]", test.q, test.expected, found)
+		}
+	}
+}
+
+func TestPublishHistory_FileIsSet_HistorySurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := historyConfig{file: filepath.Join(dir, "history.json")}
+
+	h, err := newPublishHistory(conf)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	h.record(newHistoryEntry("tid_1", videoEvent{Video: "1"}))
+
+	reloaded, err := newPublishHistory(conf)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	found := reloaded.find(historyQuery{videoID: "1"})
+	if len(found) != 1 || found[0].TransactionID != "tid_1" {
+		t.Fatalf("Expected persisted entry to be loaded. Found: [%#v]", found)
+	}
+}
+
+func TestHandleHistory_InvalidTimeRange_Return400StatusCode(t *testing.T) {
+	bn := &brightcoveNotifier{}
+	w := httptest.NewRecorder()
+	bn.handleHistory(w, httptest.NewRequest("GET", "/__history?from=yesterday", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400. Received status code: [%d]", w.Code)
+	}
+}
+
+func TestHandleNotification_EventIsRecordedInHistory(t *testing.T) {
+	h, err := newPublishHistory(historyConfig{})
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	bn := &brightcoveNotifier{
+		brightcoveConf: &brightcoveConfig{accountID: "775205503001"},
+		history:        h,
+	}
+	req := httptest.NewRequest("POST", "/notify", strings.NewReader(buildTestVideoEvent("unknown", "4020894387001")))
+	req.Header.Set("X-Request-Id", "tid_test")
+	bn.handleNotification(httptest.NewRecorder(), req)
+
+	found := h.find(historyQuery{transactionID: "tid_test"})
+	if len(found) != 1 || found[0].VideoID != "4020894387001" || found[0].Error == "" {
+		t.Fatalf("Expected rejected event to be recorded. Found: [%#v]", found)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/pborman/uuid"
+)
+
+const imageSetType = "ImageSet"
+
+// imageKinds are the images of the Brightcove video forwarded as members of its image set, in this order.
+var imageKinds = []string{"poster", "thumbnail"}
+
+// image is a member of the image set payload.
+type image struct {
+	UUID   string `json:"uuid"`
+	Type   string `json:"type"`
+	Kind   string `json:"kind"`
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// imageUUID is derived from the video id and the image kind, so it doesn't change with the CDN URLs of the image.
+func imageUUID(videoID, kind string) string {
+	return uuid.NewMD5(uuid.UUID{}, []byte(videoID+"/"+kind)).String()
+}
+
+func imageSetUUID(videoID string) string {
+	return imageUUID(videoID, "images")
+}
+
+// images returns the images of the video, with the largest of their sources.
+func images(video video) []image {
+	all, _ := video["images"].(map[string]interface{})
+	id, _ := video["id"].(string)
+	var imgs []image
+	for _, kind := range imageKinds {
+		img, _ := all[kind].(map[string]interface{})
+		src, _ := img["src"].(string)
+		if src == "" {
+			continue
+		}
+		i := image{UUID: imageUUID(id, kind), Type: "Image", Kind: kind, URL: src}
+		sources, _ := img["sources"].([]interface{})
+		for _, s := range sources {
+			source, _ := s.(map[string]interface{})
+			sourceSrc, _ := source["src"].(string)
+			width, _ := source["width"].(float64)
+			height, _ := source["height"].(float64)
+			if sourceSrc == "" || int(width) < i.Width || (int(width) == i.Width && !isHTTPS(sourceSrc)) {
+				continue
+			}
+			i.URL, i.Width, i.Height = sourceSrc, int(width), int(height)
+		}
+		imgs = append(imgs, i)
+	}
+	return imgs
+}
+
+func isHTTPS(src string) bool {
+	u, err := url.Parse(src)
+	return err == nil && u.Scheme == "https"
+}
+
+// addMainImage references the image set of the video from the payload, if the video has images.
+func addMainImage(_ context.Context, video video) error {
+	if len(images(video)) == 0 {
+		return nil
+	}
+	id, _ := video["id"].(string)
+	video["mainImage"] = imageSetUUID(id)
+	return nil
+}
+
+// payloads returns the payloads to deliver for the video: its image set if enabled, then the video itself.
+func (bn brightcoveNotifier) payloads(v video) []video {
+	if set := imageSet(v); bn.imageSets && set != nil {
+		return []video{set, v}
+	}
+	return []video{v}
+}
+
+// imageSet returns the companion image set payload of the video, or nil if the video has no images.
+func imageSet(video video) video {
+	imgs := images(video)
+	if len(imgs) == 0 {
+		return nil
+	}
+	id, _ := video["id"].(string)
+	return map[string]interface{}{
+		"uuid":       imageSetUUID(id),
+		"type":       imageSetType,
+		"video_id":   id,
+		"video_uuid": video["uuid"],
+		"members":    imgs,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTestImages(host string) map[string]interface{} {
+	return map[string]interface{}{
+		"poster": map[string]interface{}{
+			"src": "http://" + host + "/poster.jpg",
+			"sources": []interface{}{
+				map[string]interface{}{"src": "http://" + host + "/poster_1280.jpg", "width": 1280.0, "height": 720.0},
+				map[string]interface{}{"src": "https://" + host + "/poster_1280.jpg", "width": 1280.0, "height": 720.0},
+				map[string]interface{}{"src": "https://" + host + "/poster_640.jpg", "width": 640.0, "height": 360.0},
+			},
+		},
+		"thumbnail": map[string]interface{}{"src": "https://" + host + "/thumbnail.jpg"},
+	}
+}
+
+func TestImages_LargestSourcePickedAndUUIDsStableAcrossCDNs(t *testing.T) {
+	imgs := images(video{"id": "4020894387001", "images": buildTestImages("cdn1.example.com")})
+	if len(imgs) != 2 {
+		t.Fatalf("Expected poster and thumbnail. Found: [%+v]", imgs)
+	}
+	poster := imgs[0]
+	if poster.Kind != "poster" || poster.URL != "https://cdn1.example.com/poster_1280.jpg" || poster.Width != 1280 || poster.Height != 720 {
+		t.Errorf("Expected largest https source of the poster. Found: [%+v]", poster)
+	}
+	if imgs[1].URL != "https://cdn1.example.com/thumbnail.jpg" {
+		t.Errorf("Expected src of the thumbnail without sources. Found: [%+v]", imgs[1])
+	}
+
+	moved := images(video{"id": "4020894387001", "images": buildTestImages("cdn2.example.com")})
+	if moved[0].UUID != poster.UUID || moved[1].UUID != imgs[1].UUID {
+		t.Errorf("Expected image UUIDs not to depend on the URLs. Found: [%s] [%s]", poster.UUID, moved[0].UUID)
+	}
+	if poster.UUID == imgs[1].UUID || poster.UUID == imageSetUUID("4020894387001") {
+		t.Error("Expected distinct UUIDs for the images and the image set.")
+	}
+}
+
+func TestDeliver_ImageSets_ImageSetDeliveredBeforeVideo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "payloads.jsonl")
+	bn := &brightcoveNotifier{
+		imageSets: true,
+		sinkConf:  sinkConfig{names: []string{sinkFile}, fileWriter: newLineWriter(file)},
+	}
+
+	v := video{"id": "4020894387001", "images": buildTestImages("cdn1.example.com")}
+	if _, err := bn.transform(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bn.deliver(context.Background(), bn.payloads(v), "tid_test", nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []fileSinkLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line fileSinkLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0].Payload["type"] != imageSetType || lines[1].Payload["type"] != "video" {
+		t.Fatalf("Expected image set then video. Found: [%+v]", lines)
+	}
+	if lines[1].Payload["mainImage"] != lines[0].Payload["uuid"] || lines[0].Payload["video_uuid"] != lines[1].Payload["uuid"] {
+		t.Errorf("Expected video and image set referencing each other. Found: [%+v]", lines)
+	}
+	if members, _ := lines[0].Payload["members"].([]interface{}); len(members) != 2 {
+		t.Errorf("Expected 2 members. Found: [%v]", lines[0].Payload["members"])
+	}
+}
+
+func TestPayloads_NoImages_VideoOnly(t *testing.T) {
+	bn := brightcoveNotifier{imageSets: true}
+	v := video{"id": "4020894387001", "images": map[string]interface{}{}}
+	if err := addMainImage(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+	if payloads := bn.payloads(v); len(payloads) != 1 || v["mainImage"] != nil {
+		t.Errorf("Expected the video only, without mainImage. Found: [%v]", payloads)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/gorilla/mux"
+)
+
+const (
+	ingestStatusProcessing = "processing"
+	ingestStatusSucceeded  = "succeeded"
+	ingestStatusFailed     = "failed"
+
+	//ingestEntityTitle is the entity of the notification telling the outcome of the whole ingest job, the other ones are renditions, images, etc.
+	ingestEntityTitle = "TITLE"
+
+	forwardStatusHeld         = "held_for_ingest"
+	forwardStatusIngestFailed = "dropped_ingest_failed"
+	releaseSuffix             = "_released"
+)
+
+// ingestNotification is the callback of the Brightcove Dynamic Ingest API for one entity of an ingest job.
+type ingestNotification struct {
+	Entity        string `json:"entity"`
+	EntityType    string `json:"entityType"`
+	Version       string `json:"version"`
+	Action        string `json:"action"`
+	JobID         string `json:"jobId"`
+	VideoID       string `json:"videoId"`
+	DateTimeStamp int64  `json:"dateTimeStamp"`
+	AccountID     string `json:"accountId"`
+	Status        string `json:"status"`
+	ErrorMessage  string `json:"errorMessage"`
+}
+
+type ingestConfig struct {
+	//holdFirstPublish holds the forwards of a video still processing in Brightcove until its ingest job succeeded
+	holdFirstPublish bool
+	//held publishes are released after holdTimeout if the outcome of the ingest job wasn't notified, e.g. for videos not ingested through Dynamic Ingest
+	holdTimeout time.Duration
+	maxEntries  int
+}
+
+func (ic ingestConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tholdFirstPublish: [%t]\n\t\tholdTimeout: [%v]\n\t\tmaxEntries: [%d]\n\t", ic.holdFirstPublish, ic.holdTimeout, ic.maxEntries)
+}
+
+// ingestEntity is the last status notified for one entity of an ingest job.
+type ingestEntity struct {
+	Entity     string `json:"entity"`
+	EntityType string `json:"entity_type"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ingestJob is the status of the last ingest job of a video.
+type ingestJob struct {
+	VideoID   string         `json:"video_id"`
+	JobID     string         `json:"job_id"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Entities  []ingestEntity `json:"entities"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Held      *heldPublish   `json:"held,omitempty"`
+}
+
+// heldPublish is the video event whose forward waits for the ingest job to succeed.
+type heldPublish struct {
+	TransactionID string     `json:"transaction_id"`
+	Event         videoEvent `json:"event"`
+	HeldAt        time.Time  `json:"held_at"`
+	timer         *time.Timer
+}
+
+// ingestTracker keeps the status of the ingest jobs by video ID. A nil *ingestTracker holds nothing.
+type ingestTracker struct {
+	sync.Mutex
+	conf ingestConfig
+	jobs map[string]*ingestJob
+	//release publishes the held event once the hold timed out
+	release func(h heldPublish, reason string)
+}
+
+func newIngestTracker(conf ingestConfig) *ingestTracker {
+	return &ingestTracker{conf: conf, jobs: make(map[string]*ingestJob)}
+}
+
+// record updates the job of the video with the notification. Once the job succeeded or failed, it returns the publish it held, if any.
+func (it *ingestTracker) record(n ingestNotification) (ingestJob, *heldPublish) {
+	it.Lock()
+	defer it.Unlock()
+	job, found := it.jobs[n.VideoID]
+	if !found {
+		job = &ingestJob{VideoID: n.VideoID}
+		it.jobs[n.VideoID] = job
+		it.evict()
+	}
+	if job.JobID != n.JobID {
+		job.JobID, job.Status, job.Error, job.Entities = n.JobID, ingestStatusProcessing, "", nil
+	}
+	job.UpdatedAt = time.Now().UTC()
+	entity := ingestEntity{Entity: n.Entity, EntityType: n.EntityType, Status: n.Status, Error: n.ErrorMessage}
+	replaced := false
+	for i, e := range job.Entities {
+		if e.Entity == n.Entity && e.EntityType == n.EntityType {
+			job.Entities[i], replaced = entity, true
+		}
+	}
+	if !replaced {
+		job.Entities = append(job.Entities, entity)
+	}
+	if n.EntityType != ingestEntityTitle {
+		return *job, nil
+	}
+	if n.Status == "SUCCESS" {
+		job.Status = ingestStatusSucceeded
+	} else {
+		job.Status, job.Error = ingestStatusFailed, n.ErrorMessage
+	}
+	held := job.Held
+	job.Held = nil
+	if held != nil {
+		held.timer.Stop()
+	}
+	return *job, held
+}
+
+// evict removes the least recently updated jobs without a held publish, over maxEntries.
+func (it *ingestTracker) evict() {
+	if it.conf.maxEntries <= 0 || len(it.jobs) <= it.conf.maxEntries {
+		return
+	}
+	var jobs []*ingestJob
+	for _, job := range it.jobs {
+		if job.Held == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].UpdatedAt.Before(jobs[j].UpdatedAt) })
+	excess := len(it.jobs) - it.conf.maxEntries
+	for i := 0; i < excess && i < len(jobs); i++ {
+		delete(it.jobs, jobs[i].VideoID)
+	}
+}
+
+// hold keeps the event until the ingest job of the video succeeded, if Brightcove tells the video is still processing.
+// It doesn't hold if holding is disabled or the job already succeeded. A later event for the same video replaces the held one.
+func (it *ingestTracker) hold(event videoEvent, video video, tid string) bool {
+	if it == nil || !it.conf.holdFirstPublish || !processing(video) {
+		return false
+	}
+	it.Lock()
+	defer it.Unlock()
+	job, found := it.jobs[event.Video]
+	if !found {
+		job = &ingestJob{VideoID: event.Video, Status: ingestStatusProcessing, UpdatedAt: time.Now().UTC()}
+		it.jobs[event.Video] = job
+		it.evict()
+	}
+	if job.Status == ingestStatusSucceeded {
+		return false
+	}
+	if job.Held != nil {
+		job.Held.timer.Stop()
+	}
+	h := &heldPublish{TransactionID: tid, Event: event, HeldAt: time.Now().UTC()}
+	h.timer = time.AfterFunc(it.conf.holdTimeout, func() { it.timedOut(h) })
+	job.Held = h
+	return true
+}
+
+// processing tells if the renditions of the video are not complete yet, e.g. for a video being ingested for the first time.
+// It is decided from the video in the CMS API rather than from what this instance forwarded, which doesn't survive restarts.
+func processing(video video) bool {
+	complete, found := video["complete"].(bool)
+	return found && !complete
+}
+
+func (it *ingestTracker) timedOut(h *heldPublish) {
+	it.Lock()
+	job, found := it.jobs[h.Event.Video]
+	if !found || job.Held != h {
+		it.Unlock()
+		return
+	}
+	job.Held = nil
+	it.Unlock()
+	it.release(*h, "hold timed out")
+}
+
+func (it *ingestTracker) job(videoID string) (ingestJob, bool) {
+	if it == nil {
+		return ingestJob{}, false
+	}
+	it.Lock()
+	defer it.Unlock()
+	job, found := it.jobs[videoID]
+	if !found {
+		return ingestJob{}, false
+	}
+	return *job, true
+}
+
+func (it *ingestTracker) list() []ingestJob {
+	jobs := []ingestJob{}
+	if it == nil {
+		return jobs
+	}
+	it.Lock()
+	defer it.Unlock()
+	for _, job := range it.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].UpdatedAt.After(jobs[j].UpdatedAt) })
+	return jobs
+}
+
+// stop drops the held publishes, the next event of their videos publishes them.
+func (it *ingestTracker) stop() {
+	if it == nil {
+		return
+	}
+	it.Lock()
+	defer it.Unlock()
+	for _, job := range it.jobs {
+		if job.Held != nil {
+			job.Held.timer.Stop()
+			warnLogger.Printf("tid=%v video_id=%v Dropping publish held for ingest.", job.Held.TransactionID, job.VideoID)
+			job.Held = nil
+		}
+	}
+}
+
+// releaseHeld publishes the held event. It bypasses the hold, as forced publishes do.
+func (bn brightcoveNotifier) releaseHeld(h heldPublish, reason string) {
+	if !bn.lifecycle.accept() {
+		warnLogger.Printf("tid=%v video_id=%v Shutting down, dropping publish held for ingest.", h.TransactionID, h.Event.Video)
+		return
+	}
+	defer bn.lifecycle.done()
+	tid := h.TransactionID + releaseSuffix
+	infoLogger.Printf("tid=%v video_id=%v Releasing publish held for ingest: %s.", tid, h.Event.Video, reason)
+	entry := newHistoryEntry(tid, h.Event)
+	defer bn.history.record(entry)
+	_, _ = bn.publish(bn.lifecycle.context(), h.Event, tid, publishOptions{force: true}, entry)
+}
+
+// dropHeld records the held publish as dropped, its video failed to ingest.
+func (bn brightcoveNotifier) dropHeld(h heldPublish, job ingestJob) {
+	warnLogger.Printf("tid=%v video_id=%v job_id=%v Dropping publish held for ingest, the ingest job failed.", h.TransactionID, h.Event.Video, job.JobID)
+	entry := newHistoryEntry(h.TransactionID, h.Event)
+	entry.ForwardStatus = forwardStatusIngestFailed
+	entry.Error = fmt.Sprintf("Ingest job [%s] failed: [%s]", job.JobID, job.Error)
+	bn.history.record(entry)
+}
+
+// handleIngestCallback tracks the ingest jobs from the Dynamic Ingest callbacks. It releases the publish held for a job that succeeded,
+// and drops the one held for a job that failed.
+func (bn brightcoveNotifier) handleIngestCallback(w http.ResponseWriter, r *http.Request) {
+	tid := transactionidutils.GetTransactionIDFromRequest(r)
+	var n ingestNotification
+	err := json.NewDecoder(r.Body).Decode(&n)
+	if err != nil || n.VideoID == "" {
+		warnLogger.Printf("tid=%v Invalid ingest notification received: [%v]", tid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if bn.brightcoveConf.account() != n.AccountID {
+		warnLogger.Printf("tid=%v account_id=%v Invalid ingest notification received. Unexpected accountID. Ignoring...", tid, n.AccountID)
+		return
+	}
+	if bn.ingest == nil {
+		return
+	}
+	job, held := bn.ingest.record(n)
+	infoLogger.Printf("tid=%v video_id=%v job_id=%v Ingest notification: [%s] [%s] [%s], job [%s].", tid, n.VideoID, n.JobID, n.EntityType, n.Action, n.Status, job.Status)
+	if job.Status == ingestStatusFailed && n.EntityType == ingestEntityTitle {
+		warnLogger.Printf("tid=%v video_id=%v job_id=%v Ingest failed: [%s]", tid, n.VideoID, n.JobID, n.ErrorMessage)
+	}
+	switch {
+	case held == nil:
+	case job.Status == ingestStatusFailed:
+		bn.dropHeld(*held, job)
+	default:
+		bn.releaseHeld(*held, "ingest succeeded")
+	}
+}
+
+func (bn brightcoveNotifier) handleListIngestJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, bn.ingest.list())
+}
+
+func (bn brightcoveNotifier) handleIngestJob(w http.ResponseWriter, r *http.Request) {
+	job, found := bn.ingest.job(mux.Vars(r)["id"])
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func buildTestIngestNotification(accID, videoID, entityType, status string) string {
+	return fmt.Sprintf(`{"entity":"%s","entityType":"%s","version":"1","action":"CREATE","jobId":"job1","videoId":"%s","dateTimeStamp":1473693553735,"accountId":"%s","status":"%s"}`,
+		videoID, entityType, videoID, accID, status)
+}
+
+// newIngestTestNotifier serves the video as complete or still processing in the CMS API.
+func newIngestTestNotifier(t *testing.T, conf ingestConfig, complete bool, forwards *int) (*brightcoveNotifier, string, string) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	history, err := newPublishHistory(historyConfig{maxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:    &http.Client{},
+		history:   history,
+		forwarded: newForwardedContent(nil),
+		ingest:    newIngestTracker(conf),
+	}
+	bn.ingest.release = func(h heldPublish, reason string) { bn.releaseHeld(h, reason) }
+	t.Cleanup(bn.ingest.stop)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(strings.Replace(buildTestVideoModel(accID, videoID), `"complete": true`, fmt.Sprintf(`"complete": %t`, complete), 1)))
+		case "/cms-notifier/notify":
+			*forwards++
+		}
+	}))
+	t.Cleanup(ts.Close)
+	bn.brightcoveConf = &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID}
+	bn.cmsNotifierConf = &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"}
+	return bn, accID, videoID
+}
+
+func (bn brightcoveNotifier) postTestNotification(t *testing.T, handler http.HandlerFunc, body string) int {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	return w.Code
+}
+
+func TestIngestCallback_HoldFirstPublish_ForwardedOnceTranscodingSucceeded(t *testing.T) {
+	forwards := 0
+	bn, accID, videoID := newIngestTestNotifier(t, ingestConfig{holdFirstPublish: true, holdTimeout: time.Hour}, false, &forwards)
+
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	bn.postTestNotification(t, bn.handleIngestCallback, buildTestIngestNotification(accID, videoID, "DYNAMIC_RENDITION", "SUCCESS"))
+	if forwards != 0 {
+		t.Fatalf("Expected first publish held while transcoding. Found [%d] forwards.", forwards)
+	}
+	if entries := bn.history.find(historyQuery{videoID: videoID}); len(entries) != 1 || entries[0].ForwardStatus != forwardStatusHeld {
+		t.Fatalf("Expected held publish in history. Found: [%+v]", entries)
+	}
+
+	code := bn.postTestNotification(t, bn.handleIngestCallback, buildTestIngestNotification(accID, videoID, ingestEntityTitle, "SUCCESS"))
+	if code != http.StatusOK || forwards != 1 {
+		t.Fatalf("Expected held publish forwarded once ingest succeeded. Found: [%d] [%d] forwards.", code, forwards)
+	}
+	job, found := bn.ingest.job(videoID)
+	if !found || job.Status != ingestStatusSucceeded || job.Held != nil || len(job.Entities) != 2 {
+		t.Errorf("Expected succeeded job with 2 entities. Found: [%+v]", job)
+	}
+
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	if entries := bn.history.find(historyQuery{videoID: videoID}); entries[0].ForwardStatus != forwardStatusUnchanged {
+		t.Errorf("Expected next events not held. Found: [%s]", entries[0].ForwardStatus)
+	}
+}
+
+func TestHandleNotification_HoldFirstPublishAndVideoComplete_ForwardedWithoutHold(t *testing.T) {
+	forwards := 0
+	bn, accID, videoID := newIngestTestNotifier(t, ingestConfig{holdFirstPublish: true, holdTimeout: time.Hour}, true, &forwards)
+
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	if forwards != 1 {
+		t.Errorf("Expected edit of an existing video never forwarded by this instance not held. Found [%d] forwards.", forwards)
+	}
+	if _, found := bn.ingest.job(videoID); found {
+		t.Error("Expected no job tracked for the complete video.")
+	}
+}
+
+func TestIngestCallback_IngestFailed_HeldPublishDropped(t *testing.T) {
+	forwards := 0
+	bn, accID, videoID := newIngestTestNotifier(t, ingestConfig{holdFirstPublish: true, holdTimeout: 50 * time.Millisecond}, false, &forwards)
+
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	bn.postTestNotification(t, bn.handleIngestCallback, buildTestIngestNotification(accID, videoID, ingestEntityTitle, "FAILED"))
+	if job, _ := bn.ingest.job(videoID); job.Status != ingestStatusFailed || job.Held != nil {
+		t.Fatalf("Expected failed job without held publish. Found: [%+v]", job)
+	}
+	entries := bn.history.find(historyQuery{videoID: videoID})
+	if len(entries) != 2 || entries[0].ForwardStatus != forwardStatusIngestFailed || entries[0].TransactionID != entries[1].TransactionID {
+		t.Fatalf("Expected the held publish recorded as dropped. Found: [%+v]", entries)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if forwards != 0 || len(bn.history.find(historyQuery{videoID: videoID})) != 2 {
+		t.Errorf("Expected nothing released after the hold timeout. Found [%d] forwards.", forwards)
+	}
+}
+
+func TestIngestCallback_NoIngestOutcome_HeldPublishReleasedAfterTimeout(t *testing.T) {
+	forwards := 0
+	bn, accID, videoID := newIngestTestNotifier(t, ingestConfig{holdFirstPublish: true, holdTimeout: 50 * time.Millisecond}, false, &forwards)
+
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	bn.postTestNotification(t, bn.handleIngestCallback, buildTestIngestNotification(accID, videoID, "DYNAMIC_RENDITION", "SUCCESS"))
+	if job, _ := bn.ingest.job(videoID); job.Status != ingestStatusProcessing || job.Held == nil {
+		t.Fatalf("Expected processing job with the publish held. Found: [%+v]", job)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	entries := bn.history.find(historyQuery{videoID: videoID})
+	if len(entries) != 2 || entries[0].ForwardStatus != forwardStatusSuccess {
+		t.Fatalf("Expected held publish forwarded after the hold timeout. Found: [%+v]", entries)
+	}
+	if entries[0].TransactionID != entries[1].TransactionID+releaseSuffix {
+		t.Errorf("Expected release recorded with the held transaction ID. Found: [%s]", entries[0].TransactionID)
+	}
+}
+
+func TestIngestCallback_HoldDisabled_JobTrackedAndPublishNotHeld(t *testing.T) {
+	forwards := 0
+	bn, accID, videoID := newIngestTestNotifier(t, ingestConfig{}, false, &forwards)
+
+	bn.postTestNotification(t, bn.handleIngestCallback, buildTestIngestNotification(accID, videoID, "DYNAMIC_RENDITION", "SUCCESS"))
+	bn.postTestNotification(t, bn.handleNotification, buildTestVideoEvent(accID, videoID))
+	if job, _ := bn.ingest.job(videoID); job.Status != ingestStatusProcessing || forwards != 1 {
+		t.Errorf("Expected processing job and video forwarded. Found: [%+v] [%d] forwards.", job, forwards)
+	}
+}
+
+func TestIngestCallback_InvalidNotification_BadRequest(t *testing.T) {
+	forwards := 0
+	bn, _, _ := newIngestTestNotifier(t, ingestConfig{}, false, &forwards)
+	if code := bn.postTestNotification(t, bn.handleIngestCallback, `{"entityType": "TITLE"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400. Found: [%d]", code)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	ftMessageVersion       = "FTMSG/1.0"
+	ftMessageType          = "cms-content-published"
+	ftOriginSystemID       = "http://cmdb.ft.com/systems/brightcove"
+	ftMessageTimestampForm = "2006-01-02T15:04:05.000Z07:00"
+
+	kafkaBinaryContentType = "application/vnd.kafka.binary.v2+json"
+	//kafkaRetriableErrorCode is the error code of the Kafka REST proxy for the records that can be produced again
+	kafkaRetriableErrorCode = 2
+)
+
+type kafkaConfig struct {
+	//address of the Kafka REST proxy
+	proxyAddr string
+	topic     string
+	//attempts after the first one failed
+	retries int
+	//wait before the first retry, doubled before each of the next ones
+	retryBackoff time.Duration
+}
+
+func (kc kafkaConfig) prettyPrint() string {
+	return fmt.Sprintf("proxyAddr: [%s], topic: [%s], retries: [%d], retryBackoff: [%v]", kc.proxyAddr, kc.topic, kc.retries, kc.retryBackoff)
+}
+
+// ftMessage is a message in the FT message envelope: a version line, the headers, an empty line and the body.
+type ftMessage struct {
+	headers map[string]string
+	body    []byte
+}
+
+func newFTMessage(video video, tid string) (ftMessage, error) {
+	body, err := json.Marshal(video)
+	if err != nil {
+		return ftMessage{}, err
+	}
+	return ftMessage{
+		headers: map[string]string{
+			"Message-Id":        uuid.NewRandom().String(),
+			"Message-Timestamp": time.Now().UTC().Format(ftMessageTimestampForm),
+			"Message-Type":      ftMessageType,
+			"Origin-System-Id":  ftOriginSystemID,
+			"Content-Type":      "application/json",
+			"X-Request-Id":      tid,
+		},
+		body: body,
+	}, nil
+}
+
+func (m ftMessage) build() []byte {
+	var names []string
+	for name := range m.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteString(ftMessageVersion + "\r\n")
+	for _, name := range names {
+		b.WriteString(name + ": " + m.headers[name] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(m.body)
+	return b.Bytes()
+}
+
+// parseFTMessage is the reverse of build.
+func parseFTMessage(raw []byte) (ftMessage, error) {
+	head, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return ftMessage{}, fmt.Errorf("Invalid FT message: no empty line between headers and body.")
+	}
+	lines := strings.Split(string(head), "\r\n")
+	if lines[0] != ftMessageVersion {
+		return ftMessage{}, fmt.Errorf("Invalid FT message version: [%s]", lines[0])
+	}
+	m := ftMessage{headers: make(map[string]string), body: body}
+	for _, line := range lines[1:] {
+		name, value, found := strings.Cut(line, ": ")
+		if !found {
+			return ftMessage{}, fmt.Errorf("Invalid FT message header: [%s]", line)
+		}
+		m.headers[name] = value
+	}
+	return m, nil
+}
+
+// Records and responses of the Kafka REST proxy API, with binary keys and values encoded in base64.
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaOffset struct {
+	Partition int     `json:"partition"`
+	Offset    int64   `json:"offset"`
+	ErrorCode *int    `json:"error_code"`
+	Error     *string `json:"error"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []kafkaOffset `json:"offsets"`
+}
+
+// kafkaProduceError is a failed attempt to produce a message, retriable or not.
+type kafkaProduceError struct {
+	err       error
+	retriable bool
+}
+
+func (e *kafkaProduceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kafkaProduceError) Unwrap() error {
+	return e.err
+}
+
+// kafkaSink produces the payloads in the FT message envelope to a Kafka topic through the Kafka REST proxy, keyed by uuid.
+// A delivery succeeds once the proxy acknowledged the record with its offset. Failed attempts are retried with exponential backoff,
+// unless the proxy rejected the record as not retriable.
+type kafkaSink struct {
+	bn   brightcoveNotifier
+	conf kafkaConfig
+}
+
+func (s kafkaSink) name() string {
+	return sinkKafka
+}
+
+func (s kafkaSink) deliver(ctx context.Context, video video, tid string) (string, error) {
+	msg, err := newFTMessage(video, tid)
+	if err != nil {
+		return "", err
+	}
+	key, _ := video["uuid"].(string)
+	record := kafkaRecord{
+		Key:   base64.StdEncoding.EncodeToString([]byte(key)),
+		Value: base64.StdEncoding.EncodeToString(msg.build()),
+	}
+	backoff := s.conf.retryBackoff
+	for attempt := 0; ; attempt++ {
+		offset, err := s.produce(ctx, record, tid)
+		if err == nil {
+			infoLogger.Printf("tid=%v video_id=%v uuid=%v Message [%s] produced to [%s] partition [%d] offset [%d].",
+				tid, video["id"], key, msg.headers["Message-Id"], s.conf.topic, offset.Partition, offset.Offset)
+			return "", nil
+		}
+		if !err.retriable || attempt >= s.conf.retries {
+			return "", err
+		}
+		warnLogger.Printf("tid=%v video_id=%v Producing message failed, retrying in [%v]: [%v]", tid, video["id"], backoff, err)
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s kafkaSink) produce(ctx context.Context, record kafkaRecord, tid string) (kafkaOffset, *kafkaProduceError) {
+	body, err := json.Marshal(kafkaRecords{[]kafkaRecord{record}})
+	if err != nil {
+		return kafkaOffset{}, &kafkaProduceError{err, false}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(s.conf.proxyAddr, "/")+"/topics/"+s.conf.topic, bytes.NewReader(body))
+	if err != nil {
+		return kafkaOffset{}, &kafkaProduceError{err, false}
+	}
+	req.Header.Add("Content-Type", kafkaBinaryContentType)
+	req.Header.Add("Accept", "application/vnd.kafka.v2+json")
+	req.Header.Add("X-Request-Id", tid)
+	injectTraceContext(ctx, req)
+	resp, err := s.bn.do(upstreamKafkaProxy, req)
+	if err != nil {
+		return kafkaOffset{}, &kafkaProduceError{err, ctx.Err() == nil && !isCircuitOpen(err)}
+	}
+	defer cleanupResp(resp)
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("Invalid statusCode received: [%d]", resp.StatusCode)
+		return kafkaOffset{}, &kafkaProduceError{err, resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests}
+	}
+	var produced kafkaProduceResponse
+	err = json.NewDecoder(resp.Body).Decode(&produced)
+	if err != nil {
+		return kafkaOffset{}, &kafkaProduceError{err, true}
+	}
+	if len(produced.Offsets) != 1 {
+		return kafkaOffset{}, &kafkaProduceError{fmt.Errorf("Expected 1 acknowledged record, found [%d].", len(produced.Offsets)), true}
+	}
+	offset := produced.Offsets[0]
+	if offset.ErrorCode != nil {
+		var msg string
+		if offset.Error != nil {
+			msg = *offset.Error
+		}
+		err := fmt.Errorf("Record not acknowledged: error code [%d] [%s]", *offset.ErrorCode, msg)
+		return offset, &kafkaProduceError{err, *offset.ErrorCode == kafkaRetriableErrorCode}
+	}
+	return offset, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type producedMessage struct {
+	key    string
+	offset int64
+	msg    ftMessage
+}
+
+// kafkaStandIn stands in for the Kafka REST proxy and the broker behind it, keeping the produced messages by topic.
+type kafkaStandIn struct {
+	sync.Mutex
+	*httptest.Server
+	topics   map[string][]producedMessage
+	attempts int
+	//responses to the next attempts: an HTTP status code, or a record error code if negative
+	failures []int
+}
+
+func newKafkaStandIn(t *testing.T) *kafkaStandIn {
+	k := &kafkaStandIn{topics: make(map[string][]producedMessage)}
+	k.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k.Lock()
+		defer k.Unlock()
+		k.attempts++
+		if r.Header.Get("Content-Type") != kafkaBinaryContentType || !strings.HasPrefix(r.URL.Path, "/topics/") {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if len(k.failures) > 0 {
+			failure := k.failures[0]
+			k.failures = k.failures[1:]
+			if failure > 0 {
+				w.WriteHeader(failure)
+				return
+			}
+			code, msg := -failure, "stand-in failure"
+			_ = json.NewEncoder(w).Encode(kafkaProduceResponse{[]kafkaOffset{{ErrorCode: &code, Error: &msg}}})
+			return
+		}
+		var records kafkaRecords
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		topic := strings.TrimPrefix(r.URL.Path, "/topics/")
+		var offsets []kafkaOffset
+		for _, record := range records.Records {
+			key, _ := base64.StdEncoding.DecodeString(record.Key)
+			value, _ := base64.StdEncoding.DecodeString(record.Value)
+			msg, err := parseFTMessage(value)
+			if err != nil {
+				t.Errorf("Invalid message produced: [%v]", err)
+			}
+			offset := int64(len(k.topics[topic]))
+			k.topics[topic] = append(k.topics[topic], producedMessage{string(key), offset, msg})
+			offsets = append(offsets, kafkaOffset{Offset: offset})
+		}
+		_ = json.NewEncoder(w).Encode(kafkaProduceResponse{offsets})
+	}))
+	return k
+}
+
+func (k *kafkaStandIn) messages(topic string) []producedMessage {
+	k.Lock()
+	defer k.Unlock()
+	return k.topics[topic]
+}
+
+func newKafkaTestNotifier(t *testing.T, k *kafkaStandIn, forwards *int) (*brightcoveNotifier, *httptest.Server) {
+	bn := &brightcoveNotifier{
+		client:   &http.Client{},
+		failures: newFailureStore(10),
+		sinkConf: sinkConfig{
+			names: []string{sinkKafka},
+			kafka: kafkaConfig{proxyAddr: k.URL, topic: "NativeCmsPublicationEvents", retries: 2, retryBackoff: time.Millisecond},
+		},
+	}
+	return bn, newDryRunTestServer(bn, "775205503001", "4020894387001", forwards)
+}
+
+func TestKafkaSink_Publish_FTMessageProducedKeyedByUUID(t *testing.T) {
+	k := newKafkaStandIn(t)
+	defer k.Close()
+	forwards := 0
+	bn, ts := newKafkaTestNotifier(t, k, &forwards)
+	defer ts.Close()
+
+	payload, err := bn.publish(context.Background(), videoEvent{Video: "4020894387001"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	if err != nil {
+		t.Fatalf("Expected message to be produced. Found: [%v]", err)
+	}
+	produced := k.messages("NativeCmsPublicationEvents")
+	if len(produced) != 1 {
+		t.Fatalf("Expected 1 message. Found: [%d]", len(produced))
+	}
+	if produced[0].key != payload["uuid"] {
+		t.Errorf("Expected message keyed by uuid [%v]. Found: [%s]", payload["uuid"], produced[0].key)
+	}
+	headers := produced[0].msg.headers
+	for _, h := range []string{"Message-Id", "Message-Timestamp", "Origin-System-Id", "X-Request-Id", "Content-Type"} {
+		if headers[h] == "" {
+			t.Errorf("Expected header [%s] in the message envelope.", h)
+		}
+	}
+	if headers["X-Request-Id"] != "tid_test" || headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected headers: [%v]", headers)
+	}
+	if _, err := time.Parse(ftMessageTimestampForm, headers["Message-Timestamp"]); err != nil {
+		t.Errorf("Invalid Message-Timestamp: [%v]", err)
+	}
+	var body video
+	if err := json.Unmarshal(produced[0].msg.body, &body); err != nil || body["uuid"] != payload["uuid"] {
+		t.Errorf("Expected payload as message body. Found: [%s]", produced[0].msg.body)
+	}
+	if forwards != 0 {
+		t.Errorf("Expected CMS Notifier not to be called. Found [%d] forwards.", forwards)
+	}
+}
+
+func TestKafkaSink_TransientFailures_RetriedUntilAcknowledged(t *testing.T) {
+	k := newKafkaStandIn(t)
+	defer k.Close()
+	k.failures = []int{http.StatusServiceUnavailable, -kafkaRetriableErrorCode}
+	forwards := 0
+	bn, ts := newKafkaTestNotifier(t, k, &forwards)
+	defer ts.Close()
+
+	_, err := bn.publish(context.Background(), videoEvent{Video: "4020894387001"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	if err != nil {
+		t.Fatalf("Expected message to be produced after retries. Found: [%v]", err)
+	}
+	if k.attempts != 3 || len(k.messages("NativeCmsPublicationEvents")) != 1 {
+		t.Errorf("Expected 1 message produced in 3 attempts. Found [%d] attempts.", k.attempts)
+	}
+}
+
+func TestKafkaSink_RecordRejected_NotRetriedAndFailureKept(t *testing.T) {
+	k := newKafkaStandIn(t)
+	defer k.Close()
+	k.failures = []int{-1}
+	forwards := 0
+	bn, ts := newKafkaTestNotifier(t, k, &forwards)
+	defer ts.Close()
+
+	_, err := bn.publish(context.Background(), videoEvent{Video: "4020894387001"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	if err == nil {
+		t.Fatal("Expected delivery error.")
+	}
+	if k.attempts != 1 {
+		t.Errorf("Expected no retry of a rejected record. Found [%d] attempts.", k.attempts)
+	}
+	if f, found := bn.failures.get("tid_test"); !found || len(f.Sinks) != 1 || f.Sinks[0] != sinkKafka {
+		t.Errorf("Expected failure of the kafka sink. Found: [%+v]", f)
+	}
+}
+
+func TestKafkaSink_RetriesExhausted_Fails(t *testing.T) {
+	k := newKafkaStandIn(t)
+	defer k.Close()
+	k.failures = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	forwards := 0
+	bn, ts := newKafkaTestNotifier(t, k, &forwards)
+	defer ts.Close()
+
+	_, err := bn.publish(context.Background(), videoEvent{Video: "4020894387001"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	if err == nil {
+		t.Fatal("Expected delivery error.")
+	}
+	if k.attempts != 3 {
+		t.Errorf("Expected 1 attempt and 2 retries. Found [%d] attempts.", k.attempts)
+	}
+}
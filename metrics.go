@@ -0,0 +1,20 @@
+package main
+
+import (
+	"expvar"
+)
+
+const (
+	metricForwardSuccess   = "forward_success"
+	metricForwardFailure   = "forward_failure"
+	metricForwardUnchanged = "forward_skipped_unchanged"
+	metricPayloadInvalid   = "payload_invalid"
+	metricFailureEvicted   = "failure_evicted"
+)
+
+// pipelineMetrics are the counters of the notification pipeline, served on /__metrics.
+var pipelineMetrics = expvar.NewMap("brightcove_notifier")
+
+func incMetric(name string) {
+	pipelineMetrics.Add(name, 1)
+}
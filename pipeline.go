@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	stageFetch     = "fetch"
+	stageTransform = "transform"
+	stageValidate  = "validate"
+	stageForward   = "forward"
+)
+
+// pipelineError is the failure of one of the stages of publishing a video.
+type pipelineError struct {
+	stage string
+	err   error
+	//queueFull is set if the event failed on an open circuit breaker but could not be queued for replay
+	queueFull bool
+}
+
+func (e *pipelineError) Error() string {
+	return fmt.Sprintf("%s stage failed: %v", e.stage, e.err)
+}
+
+// stageBudgets are the maximum durations of the stages of publishing a video. Zero means no limit.
+type stageBudgets struct {
+	fetch     time.Duration
+	transform time.Duration
+	forward   time.Duration
+	//renewing the access token happens within the fetch stage, or within health checks
+	token time.Duration
+}
+
+func (sb stageBudgets) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tfetch: [%v]\n\t\ttransform: [%v]\n\t\tforward: [%v]\n\t\ttoken: [%v]\n\t", sb.fetch, sb.transform, sb.forward, sb.token)
+}
+
+// withBudget derives the context of a stage, with a deadline if the budget is set.
+func withBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// stageError wraps the error of the stage, logging if it happened because the stage was interrupted:
+// the request was cancelled (e.g. Brightcove gave up on the webhook delivery, or shutdown timed out) or the stage ran out of budget.
+func (bn brightcoveNotifier) stageError(ctx context.Context, tid string, stage string, err error) *pipelineError {
+	switch {
+	case ctx.Err() != nil:
+		warnLogger.Printf("tid=%v Publishing cancelled during the [%s] stage: [%v]", tid, stage, ctx.Err())
+	case isDeadlineExceeded(err):
+		warnLogger.Printf("tid=%v Publishing interrupted: the [%s] stage ran out of its budget.", tid, stage)
+	}
+	return &pipelineError{stage: stage, err: err}
+}
+
+func isDeadlineExceeded(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// warningsKey is the context key of the warnings collected while transforming a video.
+type warningsKey struct{}
+
+// transformWarnings are the issues found by the transformations that don't stop the video from being forwarded.
+type transformWarnings struct {
+	sync.Mutex
+	msgs []string
+}
+
+func withWarnings(ctx context.Context) (context.Context, *transformWarnings) {
+	w := &transformWarnings{}
+	return context.WithValue(ctx, warningsKey{}, w), w
+}
+
+// warn records the warning in the context, if it collects warnings.
+func warn(ctx context.Context, format string, args ...interface{}) {
+	w, ok := ctx.Value(warningsKey{}).(*transformWarnings)
+	if !ok {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.msgs = append(w.msgs, fmt.Sprintf(format, args...))
+}
+
+func (w *transformWarnings) list() []string {
+	w.Lock()
+	defer w.Unlock()
+	return append([]string(nil), w.msgs...)
+}
+
+type publishOptions struct {
+	//force forwards the video even if its content didn't change since the last forward
+	force  bool
+	dryRun bool
+	//sinks restricts the delivery to these sinks, e.g. the ones that failed when replaying
+	sinks []string
+}
+
+// publish fetches the video of the event, transforms it to the UPP payload and delivers it to the sinks.
+// It returns the payload even if it was not forwarded, because it was unchanged or because of dry-run.
+// Errors are *pipelineErrors telling the stage that failed.
+func (bn brightcoveNotifier) publish(ctx context.Context, event videoEvent, tid string, opts publishOptions, entry *historyEntry) (video, error) {
+	//a notifier started in dry-run never forwards, whoever publishes
+	opts.dryRun = opts.dryRun || bn.dryRun
+	stageCtx, cancel := withBudget(ctx, bn.budgets.fetch)
+	video, err := bn.fetchVideo(stageCtx, event, tid)
+	cancel()
+	entry.fetched(video, err)
+	if err != nil {
+		return nil, bn.failed(event, tid, opts, bn.stageError(ctx, tid, stageFetch, err))
+	}
+	if video["error_code"] != nil {
+		infoLogger.Printf("tid=%v video_id=%s Video was not found in Brightcove API.", tid, video["id"])
+	} else {
+		infoLogger.Printf("tid=%v video_id=%s Fetching video successful.", tid, video["id"])
+	}
+
+	stageCtx, cancel = withBudget(ctx, bn.budgets.transform)
+	stageCtx, warnings := withWarnings(transactionidutils.TransactionAwareContext(stageCtx, tid))
+	_, err = bn.transform(stageCtx, video)
+	cancel()
+	entry.Warnings = warnings.list()
+	for _, w := range entry.Warnings {
+		warnLogger.Printf("tid=%v video_id=%v %s", tid, video["id"], w)
+	}
+	if err != nil {
+		entry.failed(err)
+		return nil, bn.failed(event, tid, opts, bn.stageError(ctx, tid, stageTransform, err))
+	}
+	infoLogger.Printf("tid=%v video_id=%v uuid=%v Generated uuid for video.", tid, video["id"], video["uuid"])
+	payloads := bn.payloads(video)
+	err = bn.validatePayloads(payloads)
+	if err != nil {
+		incMetric(metricPayloadInvalid)
+		if bn.validation.rejects() {
+			entry.failed(err)
+			return nil, bn.failed(event, tid, opts, &pipelineError{stage: stageValidate, err: err})
+		}
+		entry.Warnings = append(entry.Warnings, err.Error())
+		warnLogger.Printf("tid=%v video_id=%v %v", tid, video["id"], err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(uuidAttr(video["uuid"]))
+
+	hash := bn.forwarded.hash(video)
+	if opts.dryRun {
+		entry.skipped(video, hash)
+		entry.ForwardStatus = forwardStatusDryRun
+		return video, nil
+	}
+	if !opts.force && video["error_code"] == nil && bn.ingest.hold(event, video, tid) {
+		entry.skipped(video, hash)
+		entry.ForwardStatus = forwardStatusHeld
+		infoLogger.Printf("tid=%v video_id=%s uuid=%v Publish held until the ingest job succeeds.", tid, video["id"], video["uuid"])
+		return video, nil
+	}
+	if !opts.force && bn.forwarded.unchanged(video["uuid"].(string), hash) {
+		entry.skipped(video, hash)
+		incMetric(metricForwardUnchanged)
+		infoLogger.Printf("tid=%v video_id=%s uuid=%v Content unchanged since last forward. Skipping...", tid, video["id"], video["uuid"])
+		return video, nil
+	}
+	stageCtx, cancel = withBudget(ctx, bn.budgets.forward)
+	deliveries, err := bn.deliver(stageCtx, payloads, tid, opts.sinks)
+	cancel()
+	entry.forwarded(video, hash, deliveries, err)
+	bn.stats.forwarded(err)
+	if err != nil {
+		incMetric(metricForwardFailure)
+		return video, bn.failed(event, tid, opts, bn.stageError(ctx, tid, stageForward, err))
+	}
+	incMetric(metricForwardSuccess)
+	bn.forwarded.update(video["uuid"].(string), hash)
+	infoLogger.Printf("tid=%v video_id=%s Forwarding video successful.", tid, video["id"])
+	bn.subscribers.notify(event, video, tid)
+	return video, nil
+}
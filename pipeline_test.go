@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublish_ForwardRunsOutOfBudget_ForwardStageErrorIsReturned(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		budgets:         stageBudgets{forward: 50 * time.Millisecond},
+	}
+
+	_, err := bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	pErr, ok := err.(*pipelineError)
+	if !ok || pErr.stage != stageForward || !isDeadlineExceeded(pErr.err) {
+		t.Fatalf("Expected forward stage deadline error. Found: [%v]", err)
+	}
+}
+
+func TestPublish_RequestContextCancelled_NothingIsForwarded(t *testing.T) {
+	forwards := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cms-notifier/notify" {
+			forwards++
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/"},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := bn.publish(ctx, videoEvent{Video: "4020894387001"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{}))
+	pErr, ok := err.(*pipelineError)
+	if !ok || pErr.stage != stageFetch {
+		t.Fatalf("Expected fetch stage error. Found: [%v]", err)
+	}
+	if forwards != 0 {
+		t.Fatalf("Expected nothing to be forwarded. Forwarded: [%d]", forwards)
+	}
+}
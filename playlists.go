@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	playlistChangeEvent = "playlist-change"
+	//explicitPlaylist lists its videos, the other types are smart playlists whose videos are queried
+	explicitPlaylist = "EXPLICIT"
+	//playlistVideosPageSize is the maximum page size of the videos of a playlist in the CMS API
+	playlistVideosPageSize = 100
+)
+
+type playlistConfig struct {
+	//ids of the playlists looked up for the videos, none disables the enrichment
+	ids []string
+	//memberships are fetched again after cacheTTL, or as soon as a playlist change is notified
+	cacheTTL time.Duration
+}
+
+func (pc playlistConfig) prettyPrint() string {
+	return fmt.Sprintf("ids: %v, cacheTTL: [%v]", pc.ids, pc.cacheTTL)
+}
+
+type playlist struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	ReferenceID string   `json:"reference_id"`
+	Type        string   `json:"type"`
+	VideoIDs    []string `json:"video_ids"`
+}
+
+// playlistRef is the entry of the playlists field added to the payload.
+type playlistRef struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+type cachedPlaylist struct {
+	playlist
+	fetchedAt time.Time
+}
+
+// playlistCache keeps the configured playlists with their videos.
+type playlistCache struct {
+	sync.Mutex
+	conf      playlistConfig
+	playlists map[string]cachedPlaylist
+}
+
+func newPlaylistCache(conf playlistConfig) *playlistCache {
+	return &playlistCache{conf: conf, playlists: make(map[string]cachedPlaylist)}
+}
+
+// enabled tells if any playlist is configured. A nil *playlistCache is disabled.
+func (pc *playlistCache) enabled() bool {
+	return pc != nil && len(pc.conf.ids) > 0
+}
+
+func (pc *playlistCache) get(id string) (cachedPlaylist, bool) {
+	pc.Lock()
+	defer pc.Unlock()
+	p, found := pc.playlists[id]
+	return p, found
+}
+
+// put caches the playlist and returns the previous one, if any.
+func (pc *playlistCache) put(p playlist) (cachedPlaylist, bool) {
+	pc.Lock()
+	defer pc.Unlock()
+	old, found := pc.playlists[p.ID]
+	pc.playlists[p.ID] = cachedPlaylist{p, time.Now()}
+	return old, found
+}
+
+// playlist returns the cached playlist unless it expired. If fetching it fails, the expired one is used while there is one.
+func (bn brightcoveNotifier) playlist(ctx context.Context, id string, tid string) (playlist, error) {
+	cached, found := bn.playlists.get(id)
+	if found && time.Since(cached.fetchedAt) < bn.playlists.conf.cacheTTL {
+		return cached.playlist, nil
+	}
+	p, err := bn.fetchPlaylist(ctx, id, tid)
+	if err != nil && found {
+		warnLogger.Printf("tid=%v playlist_id=%s Fetching playlist failed, using the one fetched at [%v]: [%v]", tid, id, cached.fetchedAt, err)
+		return cached.playlist, nil
+	}
+	if err != nil {
+		return playlist{}, err
+	}
+	bn.playlists.put(p)
+	return p, nil
+}
+
+// fetchPlaylist fetches the playlist with its video ids, querying the videos of smart playlists page by page.
+func (bn brightcoveNotifier) fetchPlaylist(ctx context.Context, id string, tid string) (p playlist, err error) {
+	ctx, span := startSpan(ctx, "fetchPlaylist")
+	defer func() { endSpan(span, err) }()
+	err = bn.getFromAPI(ctx, "/playlists/"+id, tid, &p)
+	if err != nil || p.Type == explicitPlaylist {
+		return p, err
+	}
+	p.VideoIDs = nil
+	for offset := 0; ; offset += playlistVideosPageSize {
+		var videos []video
+		err = bn.getFromAPI(ctx, fmt.Sprintf("/playlists/%s/videos?limit=%d&offset=%d", id, playlistVideosPageSize, offset), tid, &videos)
+		if err != nil {
+			return p, err
+		}
+		for _, v := range videos {
+			if videoID, ok := v["id"].(string); ok {
+				p.VideoIDs = append(p.VideoIDs, videoID)
+			}
+		}
+		if len(videos) < playlistVideosPageSize {
+			return p, nil
+		}
+	}
+}
+
+// addPlaylists adds the configured playlists containing the video to the payload, in the configured order.
+// Playlists that can't be looked up are left out with a warning, they don't stop the video from being forwarded.
+func (bn brightcoveNotifier) addPlaylists(ctx context.Context, video video) error {
+	if video["error_code"] != nil {
+		return nil
+	}
+	tid, _ := transactionidutils.GetTransactionIDFromContext(ctx)
+	refs := []playlistRef{}
+	for _, id := range bn.playlists.conf.ids {
+		p, err := bn.playlist(ctx, id, tid)
+		if err != nil {
+			warn(ctx, "Playlist [%s] skipped, looking it up failed: [%v]", id, err)
+			continue
+		}
+		if contains(p.VideoIDs, fmt.Sprint(video["id"])) {
+			refs = append(refs, playlistRef{p.ID, p.Name, p.ReferenceID})
+		}
+	}
+	video["playlists"] = refs
+	return nil
+}
+
+// playlistChanged refreshes the changed playlist, if configured, and returns the videos added to it or removed from it.
+// If the playlist wasn't cached, its removed videos are unknown and all its videos are returned.
+func (bn brightcoveNotifier) playlistChanged(ctx context.Context, id string, tid string) ([]string, error) {
+	if !bn.playlists.enabled() || !contains(bn.playlists.conf.ids, id) {
+		return nil, nil
+	}
+	p, err := bn.fetchPlaylist(ctx, id, tid)
+	if err != nil {
+		return nil, err
+	}
+	old, _ := bn.playlists.put(p)
+	before := make(map[string]bool)
+	for _, videoID := range old.VideoIDs {
+		before[videoID] = true
+	}
+	var affected []string
+	for _, videoID := range p.VideoIDs {
+		if !before[videoID] {
+			affected = append(affected, videoID)
+		}
+		delete(before, videoID)
+	}
+	for videoID := range before {
+		affected = append(affected, videoID)
+	}
+	sort.Strings(affected)
+	return affected, nil
+}
+
+// handlePlaylistChange re-forwards the videos whose membership of the playlist changed, in the background
+// so Brightcove doesn't time out delivering the notification. In dry-run, the videos are published without being forwarded.
+func (bn brightcoveNotifier) handlePlaylistChange(ctx context.Context, w http.ResponseWriter, event videoEvent, tid string, dryRun bool, entry *historyEntry) {
+	affected, err := bn.playlistChanged(ctx, event.Playlist, tid)
+	if err != nil {
+		entry.failed(err)
+		warnLogger.Printf("tid=%v playlist_id=%v Refreshing playlist failed: [%v]", tid, event.Playlist, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(affected) == 0 {
+		infoLogger.Printf("tid=%v playlist_id=%v No video to re-forward for the playlist change.", tid, event.Playlist)
+		return
+	}
+	if !bn.lifecycle.accept() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	infoLogger.Printf("tid=%v playlist_id=%v Re-forwarding videos %v for the playlist change.", tid, event.Playlist, affected)
+	go func() {
+		defer bn.lifecycle.done()
+		for _, videoID := range affected {
+			videoTID := tid + "_" + videoID
+			ve := videoEvent{TimeStamp: event.TimeStamp, AccountID: event.AccountID, Event: playlistChangeEvent, Video: videoID, Version: event.Version}
+			videoEntry := newHistoryEntry(videoTID, ve)
+			_, _ = bn.publish(bn.lifecycle.context(), ve, videoTID, publishOptions{dryRun: dryRun}, videoEntry)
+			bn.history.record(videoEntry)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
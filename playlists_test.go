@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// playlistStandIn serves the videos, an explicit playlist p1 with the given videos and a smart playlist p2 with the first video,
+// and records the video ids forwarded to the CMS Notifier.
+type playlistStandIn struct {
+	sync.Mutex
+	explicit  []string
+	forwarded []string
+}
+
+func newPlaylistTestNotifier(t *testing.T, accID string, explicit []string) (*brightcoveNotifier, *playlistStandIn) {
+	s := &playlistStandIn{explicit: explicit}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		switch {
+		case r.URL.Path == "/accounts/"+accID+"/playlists/p1":
+			_ = json.NewEncoder(w).Encode(playlist{ID: "p1", Name: "News", ReferenceID: "news", Type: explicitPlaylist, VideoIDs: s.explicit})
+		case r.URL.Path == "/accounts/"+accID+"/playlists/p2":
+			_, _ = w.Write([]byte(`{"id": "p2", "name": "Latest", "type": "ACTIVATED_NEWEST_TO_OLDEST"}`))
+		case r.URL.Path == "/accounts/"+accID+"/playlists/p2/videos":
+			_, _ = fmt.Fprintf(w, `[{"id": "%s"}]`, explicit[0])
+		case strings.HasPrefix(r.URL.Path, "/accounts/"+accID+"/videos/"):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, strings.TrimPrefix(r.URL.Path, "/accounts/"+accID+"/videos/"))))
+		case r.URL.Path == "/cms-notifier/notify":
+			var v video
+			_ = json.NewDecoder(r.Body).Decode(&v)
+			s.forwarded = append(s.forwarded, v["id"].(string))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		playlists:       newPlaylistCache(playlistConfig{ids: []string{"p1", "p2", "p3"}, cacheTTL: time.Hour}),
+		lifecycle:       newLifecycle(),
+	}
+	return bn, s
+}
+
+func TestAddPlaylists_VideoInExplicitAndSmartPlaylists_MembershipAdded(t *testing.T) {
+	accID := "775205503001"
+	bn, _ := newPlaylistTestNotifier(t, accID, []string{"1", "2"})
+	bn.playlists.conf.ids = []string{"p1", "p2"}
+
+	v := video{"id": "1"}
+	if err := bn.addPlaylists(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+	refs, _ := v["playlists"].([]playlistRef)
+	if len(refs) != 2 || refs[0] != (playlistRef{"p1", "News", "news"}) || refs[1].ID != "p2" {
+		t.Errorf("Expected both playlists. Found: [%+v]", v["playlists"])
+	}
+
+	v = video{"id": "2"}
+	if err := bn.addPlaylists(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := v["playlists"].([]playlistRef); len(refs) != 1 || refs[0].ID != "p1" {
+		t.Errorf("Expected the explicit playlist only. Found: [%+v]", v["playlists"])
+	}
+}
+
+func TestAddPlaylists_PlaylistNotFound_WarnedAndLeftOut(t *testing.T) {
+	bn, _ := newPlaylistTestNotifier(t, "775205503001", []string{"1"})
+	v := video{"id": "1"}
+	ctx, warnings := withWarnings(context.Background())
+	if _, err := bn.transform(ctx, v); err != nil {
+		t.Fatalf("Expected transform to succeed without the missing playlist p3. Found: [%v]", err)
+	}
+	if refs, _ := v["playlists"].([]playlistRef); len(refs) != 2 {
+		t.Errorf("Expected the playlists found. Found: [%+v]", v["playlists"])
+	}
+	if list := warnings.list(); len(list) != 1 || !strings.Contains(list[0], "Playlist [p3] skipped") {
+		t.Errorf("Expected a warning for the missing playlist. Found: %v", list)
+	}
+}
+
+func TestPublish_ExpiredPlaylistFailsToRefresh_LoggedWithTransactionID(t *testing.T) {
+	accID := "775205503001"
+	bn, _ := newPlaylistTestNotifier(t, accID, []string{"1"})
+	bn.playlists.playlists["p3"] = cachedPlaylist{playlist{ID: "p3", Name: "Old"}, time.Now().Add(-2 * time.Hour)}
+	var logs bytes.Buffer
+	defer func(l *log.Logger) { warnLogger = l }(warnLogger)
+	warnLogger = log.New(&logs, "", 0)
+
+	_, err := bn.publish(context.Background(), videoEvent{Video: "1"}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{Video: "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "tid=tid_test playlist_id=p3 Fetching playlist failed") {
+		t.Errorf("Expected the refresh failure logged with the transaction id. Found: [%s]", logs.String())
+	}
+}
+
+func TestHandleNotification_PlaylistChange_AddedAndRemovedVideosReforwarded(t *testing.T) {
+	accID := "775205503001"
+	bn, s := newPlaylistTestNotifier(t, accID, []string{"1", "2"})
+	bn.playlists.conf.ids = []string{"p1", "p2"}
+	if _, err := bn.playlist(context.Background(), "p1", "tid_test"); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	s.explicit = []string{"2", "3"}
+	s.Unlock()
+
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"timestamp":1423840514446,"account_id":"%s","event":"playlist-change","playlist":"p1","version":3}`, accID)
+	bn.handleNotification(w, httptest.NewRequest("POST", "/notify", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202. Found: [%d]", w.Code)
+	}
+	if err := bn.lifecycle.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if len(s.forwarded) != 2 || s.forwarded[0] != "1" || s.forwarded[1] != "3" {
+		t.Errorf("Expected the removed and added videos re-forwarded. Found: %v", s.forwarded)
+	}
+}
+
+func TestHandleNotification_PlaylistChangeInDryRun_NothingReforwarded(t *testing.T) {
+	accID := "775205503001"
+	body := fmt.Sprintf(`{"account_id":"%s","event":"playlist-change","playlist":"p1"}`, accID)
+	for _, dryRunFlag := range []bool{true, false} {
+		bn, s := newPlaylistTestNotifier(t, accID, []string{"1", "2"})
+		bn.playlists.conf.ids = []string{"p1", "p2"}
+		bn.dryRun = dryRunFlag
+		history, err := newPublishHistory(historyConfig{maxEntries: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bn.history = history
+
+		req := httptest.NewRequest("POST", "/notify", strings.NewReader(body))
+		if !dryRunFlag {
+			req.Header.Set(dryRunHeader, "true")
+		}
+		w := httptest.NewRecorder()
+		bn.handleNotification(w, req)
+		if err := bn.lifecycle.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		s.Lock()
+		if w.Code != http.StatusAccepted || len(s.forwarded) != 0 {
+			t.Errorf("Dry-run flag [%t]: expected nothing forwarded. Found: [%d] %v", dryRunFlag, w.Code, s.forwarded)
+		}
+		s.Unlock()
+		if entries := history.find(historyQuery{videoID: "1"}); len(entries) != 1 || entries[0].ForwardStatus != forwardStatusDryRun {
+			t.Errorf("Dry-run flag [%t]: expected dry-run publish in history. Found: [%+v]", dryRunFlag, entries)
+		}
+	}
+}
+
+func TestHandleNotification_UnconfiguredPlaylistChange_Ignored(t *testing.T) {
+	accID := "775205503001"
+	bn, s := newPlaylistTestNotifier(t, accID, []string{"1"})
+
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"account_id":"%s","event":"playlist-change","playlist":"other"}`, accID)
+	bn.handleNotification(w, httptest.NewRequest("POST", "/notify", strings.NewReader(body)))
+	if w.Code != http.StatusOK || len(s.forwarded) != 0 {
+		t.Errorf("Expected nothing re-forwarded. Found: [%d] %v", w.Code, s.forwarded)
+	}
+}
+
+func TestFetchPlaylist_SmartPlaylistOverOnePageWithExpiredToken_AllVideosFetched(t *testing.T) {
+	accID := "775205503001"
+	renewals := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth" {
+			renewals++
+			_, _ = fmt.Fprintln(w, buildTestAccessTokenResponse("renewed"))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer renewed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/accounts/" + accID + "/playlists/p2":
+			_, _ = w.Write([]byte(`{"id": "p2", "name": "Latest", "type": "ACTIVATED_NEWEST_TO_OLDEST"}`))
+		case "/accounts/" + accID + "/playlists/p2/videos":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			var videos []video
+			for i := offset; i < 150 && i < offset+playlistVideosPageSize; i++ {
+				videos = append(videos, video{"id": strconv.Itoa(i)})
+			}
+			_ = json.NewEncoder(w).Encode(videos)
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:         &http.Client{},
+		brightcoveConf: &brightcoveConfig{addr: ts.URL + "/accounts/", oauthAddr: ts.URL + "/oauth", accountID: accID},
+	}
+
+	p, err := bn.fetchPlaylist(context.Background(), "p2", "tid_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.VideoIDs) != 150 || p.VideoIDs[149] != "149" {
+		t.Errorf("Expected the 150 videos of both pages. Found: [%d]", len(p.VideoIDs))
+	}
+	if renewals != 1 {
+		t.Errorf("Expected the access token renewed once. Found: [%d]", renewals)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/gorilla/mux"
+)
+
+// preview is the UPP payload of a video as it would be forwarded, with diagnostics about how it was built.
+type preview struct {
+	TransactionID string `json:"transaction_id"`
+	VideoID       string `json:"video_id"`
+	//ReferenceID the video was requested with, resolved to its video_id
+	ReferenceID     string   `json:"reference_id,omitempty"`
+	UUID            string   `json:"uuid,omitempty"`
+	FetchStatus     string   `json:"fetch_status"`
+	Error           string   `json:"error,omitempty"`
+	Warnings        []string `json:"warnings"`
+	Transformations []string `json:"transformations"`
+	Payload         video    `json:"payload,omitempty"`
+	//Companions are the payloads forwarded before the video, e.g. its image set
+	Companions []video `json:"companions,omitempty"`
+	//Violations of the payload schema, the payload would be rejected unless validation only warns
+	Violations []schemaViolation `json:"violations,omitempty"`
+}
+
+func (bn brightcoveNotifier) handlePreview(w http.ResponseWriter, r *http.Request) {
+	transactionID := transactionidutils.GetTransactionIDFromRequest(r)
+	p := preview{
+		TransactionID:   transactionID,
+		VideoID:         mux.Vars(r)["id"],
+		Warnings:        []string{},
+		Transformations: []string{},
+	}
+	status := http.StatusOK
+
+	//the video is fetched as publishing fetches it, within the fetch budget and with the reference ids resolved first
+	fetchCtx, cancel := withBudget(r.Context(), bn.budgets.fetch)
+	defer cancel()
+	if isReferenceID(p.VideoID) {
+		videoID, err := bn.resolveReferenceID(fetchCtx, p.VideoID, transactionID)
+		if err != nil {
+			warnLogger.Printf("tid=%v video_id=%v Preview: resolving reference id unsuccessful: [%v]", transactionID, p.VideoID, err)
+			p.FetchStatus, p.Error = fetchStatusFailed, err.Error()
+			status = http.StatusBadGateway
+			if sErr, ok := err.(*apiStatusError); ok && sErr.statusCode == http.StatusNotFound {
+				status = http.StatusNotFound
+			}
+			bn.writePreview(w, status, p)
+			return
+		}
+		p.ReferenceID, p.VideoID = p.VideoID, videoID
+	}
+	video, err := bn.fetchVideo(fetchCtx, videoEvent{Video: p.VideoID}, transactionID)
+	cancel()
+	p.FetchStatus = fetchStatus(video, err)
+	if err != nil {
+		warnLogger.Printf("tid=%v video_id=%v Preview: fetching video unsuccessful: %v", transactionID, p.VideoID, err)
+		p.Error = err.Error()
+		bn.writePreview(w, http.StatusBadGateway, p)
+		return
+	}
+
+	ctx, warnings := withWarnings(transactionidutils.TransactionAwareContext(r.Context(), transactionID))
+	p.Transformations, err = bn.transform(ctx, video)
+	p.Warnings = append(p.Warnings, warnings.list()...)
+	if err != nil {
+		p.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+	payloads := bn.payloads(video)
+	if err == nil {
+		err = bn.validatePayloads(payloads)
+		if vErr, ok := err.(*schemaValidationError); ok {
+			p.Violations = vErr.violations
+			if bn.validation.rejects() {
+				p.Error = err.Error()
+				status = http.StatusUnprocessableEntity
+			} else {
+				p.Warnings = append(p.Warnings, err.Error())
+			}
+		}
+	}
+	p.UUID, _ = video["uuid"].(string)
+	p.Warnings = append(p.Warnings, validationWarnings(video)...)
+	p.Payload = video
+	if len(payloads) > 1 {
+		p.Companions = payloads[:len(payloads)-1]
+	}
+	infoLogger.Printf("tid=%v video_id=%v uuid=%v Preview generated with [%d] warnings.", transactionID, p.VideoID, p.UUID, len(p.Warnings))
+	bn.writePreview(w, status, p)
+}
+
+func (bn brightcoveNotifier) writePreview(w http.ResponseWriter, status int, p preview) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(p)
+	if err != nil {
+		warnLogger.Printf("tid=%v Could not write preview response: [%v]", p.TransactionID, err)
+	}
+}
+
+// validationWarnings lists the issues of the payload that don't stop it from being forwarded,
+// but are likely to show up as metadata problems in UPP.
+func validationWarnings(video video) []string {
+	var warnings []string
+	if code, found := video["error_code"]; found {
+		warnings = append(warnings, fmt.Sprintf("Video not found in Brightcove API: [%v]. A delete event would be forwarded.", code))
+		return warnings
+	}
+	for _, field := range []string{"name", "account_id", "created_at", "updated_at"} {
+		if v, ok := video[field].(string); !ok || v == "" {
+			warnings = append(warnings, fmt.Sprintf("Field [%s] is missing or empty.", field))
+		}
+	}
+	if state, _ := video["state"].(string); state != "ACTIVE" {
+		warnings = append(warnings, fmt.Sprintf("Video state is [%v], not ACTIVE.", video["state"]))
+	}
+	if complete, _ := video["complete"].(bool); !complete {
+		warnings = append(warnings, "Video is not complete: renditions may still be processing.")
+	}
+	return warnings
+}
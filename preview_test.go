@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+func TestHandlePreview_VideoFound_PayloadAndDiagnosticsAreReturned(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	bn := &brightcoveNotifier{client: &http.Client{}}
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	p := requestPreview(t, bn, videoID, http.StatusOK)
+
+	if p.FetchStatus != fetchStatusFound || p.UUID == "" || p.Payload["uuid"] != p.UUID {
+		t.Fatalf("Expected found video with uuid. Found: [%#v]", p)
+	}
+	if len(p.Transformations) != 1 || p.Transformations[0] != "upp_required_fields" {
+		t.Fatalf("Unexpected transformations: [%v]", p.Transformations)
+	}
+	if forwards != 0 {
+		t.Fatalf("Expected no forwards on preview. Forwarded: [%d]", forwards)
+	}
+}
+
+func TestHandlePreview_VideoNotFound_WarningIsReturned(t *testing.T) {
+	ts := mockBrightcoveServer(`[{ "error_code": "RESOURCE_NOT_FOUND" }]`)
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:         &http.Client{},
+		brightcoveConf: &brightcoveConfig{addr: ts.URL},
+	}
+
+	p := requestPreview(t, bn, "4020894387001", http.StatusOK)
+
+	if p.FetchStatus != fetchStatusNotFound || len(p.Warnings) != 1 {
+		t.Fatalf("Expected not found video with a warning. Found: [%#v]", p)
+	}
+}
+
+func TestHandlePreview_ReferenceID_ResolvedAsPublishing(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/ref:news", accID), fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`[{"error_code":"RESOURCE_NOT_FOUND"}]`))
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:         &http.Client{},
+		brightcoveConf: &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+	}
+
+	p := requestPreview(t, bn, "ref:news", http.StatusOK)
+	if p.VideoID != videoID || p.ReferenceID != "ref:news" || p.UUID != uuid.NewMD5(uuid.UUID{}, []byte(videoID)).String() {
+		t.Errorf("Expected the preview of the resolved video. Found: [%#v]", p)
+	}
+	requestPreview(t, bn, "ref:unknown", http.StatusNotFound)
+}
+
+func TestHandlePreview_FetchOverBudget_Interrupted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:         &http.Client{},
+		brightcoveConf: &brightcoveConfig{addr: ts.URL},
+		budgets:        stageBudgets{fetch: 50 * time.Millisecond},
+	}
+
+	start := time.Now()
+	p := requestPreview(t, bn, "4020894387001", http.StatusBadGateway)
+	if time.Since(start) > 500*time.Millisecond || p.FetchStatus != fetchStatusFailed {
+		t.Errorf("Expected the fetch interrupted by its budget. Found: [%v] [%#v]", time.Since(start), p)
+	}
+}
+
+func TestValidationWarnings_InactiveIncompleteVideo_WarningsAreReturned(t *testing.T) {
+	warnings := validationWarnings(video{
+		"id":         "4020894387001",
+		"name":       "sea_marvels.mp4",
+		"account_id": "775205503001",
+		"created_at": "2015-09-17T16:08:37.108Z",
+		"updated_at": "2015-09-17T17:41:20.782Z",
+		"state":      "INACTIVE",
+		"complete":   false,
+	})
+	if len(warnings) != 2 {
+		t.Fatalf("Expected state and completeness warnings. Found: [%v]", warnings)
+	}
+}
+
+func requestPreview(t *testing.T, bn *brightcoveNotifier, videoID string, expectedStatus int) preview {
+	r := mux.NewRouter()
+	r.HandleFunc("/preview/{id}", bn.handlePreview)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/preview/%s", videoID), nil))
+	if w.Code != expectedStatus {
+		t.Fatalf("Expected status code [%d]. Received: [%d]", expectedStatus, w.Code)
+	}
+	var p preview
+	err := json.NewDecoder(w.Body).Decode(&p)
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	return p
+}
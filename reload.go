@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reloader re-reads the config file and swaps the settings that can change without a restart:
+// the Brightcove and CMS Notifier addresses and credentials, and the HTTP clients.
+// A nil *reloader has nothing to reload.
+type reloader struct {
+	sync.Mutex
+	file    string
+	options *configOptions
+	//swap applies the reloaded options to the running app
+	swap func() error
+}
+
+func (rl *reloader) reload() ([]optionChange, error) {
+	if rl == nil || rl.file == "" {
+		return nil, fmt.Errorf("No config file to reload.")
+	}
+	rl.Lock()
+	defer rl.Unlock()
+	saved := rl.options.snapshot()
+	changes, err := rl.options.reload(rl.file)
+	if err != nil {
+		return nil, err
+	}
+	err = rl.swap()
+	if err != nil {
+		//the options are read by swap, so they are only kept if it succeeds, otherwise the next refresh would apply them
+		rl.options.restore(saved)
+		return nil, err
+	}
+	return changes, nil
+}
+
+// refreshEvery applies the current options again periodically, reading again the secrets given by reference.
+func (rl *reloader) refreshEvery(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := rl.refresh(); err != nil {
+				warnLogger.Printf("Could not refresh secrets, keeping the current ones: [%v]", err)
+			}
+		}
+	}()
+}
+
+func (rl *reloader) refresh() error {
+	rl.Lock()
+	defer rl.Unlock()
+	return rl.swap()
+}
+
+// reload reloads the config file and logs what changed. An invalid file changes nothing.
+func (bn brightcoveNotifier) reload() ([]optionChange, error) {
+	changes, err := bn.reloader.reload()
+	if err != nil {
+		warnLogger.Printf("Config reload failed, keeping the current config: [%v]", err)
+		return nil, err
+	}
+	for _, c := range changes {
+		if c.Applied {
+			infoLogger.Printf("Config reload: [%s] changed from [%s] to [%s].", c.Option, c.Old, c.New)
+		} else {
+			warnLogger.Printf("Config reload: [%s] changed from [%s] to [%s], restart to apply it.", c.Option, c.Old, c.New)
+		}
+	}
+	infoLogger.Printf("Config reloaded with [%d] changes.", len(changes))
+	return changes, nil
+}
+
+func (bn brightcoveNotifier) handleReload(w http.ResponseWriter, r *http.Request) {
+	changes, err := bn.reload()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if changes == nil {
+		changes = []optionChange{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// update swaps the Brightcove settings, telling if the credentials changed.
+// The access token is then dropped, so it's renewed with the new credentials.
+func (bc *brightcoveConfig) update(addr, oauthAddr, auth, accountID string) bool {
+	bc.Lock()
+	defer bc.Unlock()
+	changed := bc.oauthAddr != oauthAddr || bc.auth != auth || bc.accountID != accountID
+	if changed {
+		bc.accessToken = ""
+	}
+	bc.addr = addr
+	bc.oauthAddr = oauthAddr
+	bc.auth = auth
+	bc.accountID = accountID
+	return changed
+}
+
+// update swaps the CMS Notifier settings, telling if the credentials changed.
+func (cnc *cmsNotifierConfig) update(addr, auth, hostHeader string) bool {
+	cnc.Lock()
+	defer cnc.Unlock()
+	changed := cnc.auth != auth
+	cnc.addr = addr
+	cnc.auth = auth
+	cnc.hostHeader = hostHeader
+	return changed
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jawher/mow.cli"
+)
+
+func newReloadTestNotifier(t *testing.T, file string) *brightcoveNotifier {
+	app := cli.App("test", "")
+	opts := newConfigOptions(app)
+	cmsNotifier := opts.String(cli.StringOpt{Name: "cms-notifier", Value: "http://localhost:13080", EnvVar: "TEST_CMS_NOTIFIER"})
+	cmsNotifierAuth := opts.Secret(cli.StringOpt{Name: "cms-notifier-auth", Value: "", EnvVar: "TEST_CMS_NOTIFIER_AUTH"})
+	port := opts.Int(cli.IntOpt{Name: "port", Value: 8080, EnvVar: "TEST_PORT"})
+	opts.reloadable("cms-notifier", "cms-notifier-auth")
+	bn := &brightcoveNotifier{lifecycle: newLifecycle(), client: &http.Client{}}
+	app.Action = func() {
+		if err := opts.apply(file); err != nil {
+			t.Fatal(err)
+		}
+		bn.port = *port
+		bn.cmsNotifierConf = &cmsNotifierConfig{addr: *cmsNotifier, auth: *cmsNotifierAuth}
+		bn.reloader = &reloader{file: file, options: opts, swap: func() error {
+			auth, err := resolveSecret(*cmsNotifierAuth)
+			if err != nil {
+				return err
+			}
+			bn.cmsNotifierConf.update(*cmsNotifier, auth, "")
+			return nil
+		}}
+	}
+	if err := app.Run([]string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	return bn
+}
+
+func TestReload_CMSNotifierChanged_ForwardsToNewAddress(t *testing.T) {
+	var auth string
+	cmsNotifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer cmsNotifier.Close()
+	file := writeConfigFile(t, "cms-notifier: http://localhost:1\nport: 8080\n")
+	bn := newReloadTestNotifier(t, file)
+
+	if err := os.WriteFile(file, []byte("cms-notifier: "+cmsNotifier.URL+"\ncms-notifier-auth: Basic new\nport: 9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("POST", "/__reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected reload to succeed. Received status code: [%d], body: [%s]", w.Code, w.Body.String())
+	}
+
+	_, err := bn.fwdVideo(context.Background(), video{"id": "1", "uuid": "u"}, "tid_test")
+	if err != nil {
+		t.Fatalf("Expected forward to the reloaded address. Found: [%v]", err)
+	}
+	if auth != "Basic new" {
+		t.Errorf("Expected reloaded credentials. Found: [%s]", auth)
+	}
+	if p := bn.reloader.options.option("port"); p.String() != "8080" {
+		t.Errorf("Expected port to need a restart. Found: [%s]", p)
+	}
+}
+
+func TestReload_InvalidFile_KeepsCurrentConfig(t *testing.T) {
+	file := writeConfigFile(t, "cms-notifier: http://localhost:1\n")
+	bn := newReloadTestNotifier(t, file)
+
+	if err := os.WriteFile(file, []byte("cms-notifier: http://localhost:2\nport: eighty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("POST", "/__reload", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid file to be rejected. Received status code: [%d]", w.Code)
+	}
+	if addr, _, _ := bn.cmsNotifierConf.request(""); addr != "http://localhost:1" {
+		t.Errorf("Expected CMS Notifier address unchanged. Found: [%s]", addr)
+	}
+}
+
+func TestReload_SwapFails_OptionsRolledBackAndNotAppliedByRefresh(t *testing.T) {
+	file := writeConfigFile(t, "cms-notifier: http://localhost:1\n")
+	bn := newReloadTestNotifier(t, file)
+
+	if err := os.WriteFile(file, []byte("cms-notifier: http://localhost:2\ncms-notifier-auth: file:/missing/secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bn.reload(); err == nil {
+		t.Fatal("Expected reload to fail on the missing secret.")
+	}
+	if o := bn.reloader.options.option("cms-notifier"); o.String() != "http://localhost:1" || o.source != sourceFile {
+		t.Errorf("Expected option rolled back. Found: [%s] (%s)", o, o.source)
+	}
+	if err := bn.reloader.refresh(); err != nil {
+		t.Fatalf("Expected refresh of the current options to succeed. Found: [%v]", err)
+	}
+	if addr, _, _ := bn.cmsNotifierConf.request(""); addr != "http://localhost:1" {
+		t.Errorf("Expected CMS Notifier address unchanged after refresh. Found: [%s]", addr)
+	}
+}
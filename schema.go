@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const (
+	payloadValidationOff    = "off"
+	payloadValidationWarn   = "warn"
+	payloadValidationReject = "reject"
+)
+
+// defaultPayloadSchema describes the payloads UPP can ingest: a deleted video, the image set of a video, or a video.
+const defaultPayloadSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"$id": "https://upp.ft.com/schemas/brightcove-video/v1.json",
+	"version": "v1",
+	"if": {"required": ["error_code"]},
+	"then": {"$ref": "#/$defs/deletedVideo"},
+	"else": {
+		"if": {"required": ["type"], "properties": {"type": {"const": "ImageSet"}}},
+		"then": {"$ref": "#/$defs/imageSet"},
+		"else": {"$ref": "#/$defs/video"}
+	},
+	"$defs": {
+		"video": {
+			"type": "object",
+			"required": ["id", "uuid", "type", "account_id", "name", "state"],
+			"properties": {
+				"id": {"type": "string", "minLength": 1},
+				"uuid": {"type": "string", "format": "uuid"},
+				"type": {"const": "video"},
+				"account_id": {"type": "string", "minLength": 1},
+				"name": {"type": "string"},
+				"state": {"enum": ["ACTIVE", "INACTIVE", "PENDING", "DELETED"]},
+				"tags": {"type": "array", "items": {"type": "string"}},
+				"custom_fields": {"type": "object"},
+				"created_at": {"type": "string", "format": "date-time"},
+				"updated_at": {"type": "string", "format": "date-time"},
+				"duration": {"type": ["integer", "null"], "minimum": 0},
+				"images": {"type": "object"},
+				"text_tracks": {"type": "array"},
+				"mainImage": {"type": "string", "format": "uuid"},
+				"transcript": {"type": "string"},
+				"captions": {"type": "array", "items": {"type": "object", "required": ["language", "url"]}},
+				"playlists": {"type": "array", "items": {"type": "object", "required": ["id"]}}
+			}
+		},
+		"deletedVideo": {
+			"type": "object",
+			"required": ["id", "uuid", "error_code"],
+			"properties": {
+				"id": {"type": "string", "minLength": 1},
+				"uuid": {"type": "string", "format": "uuid"},
+				"error_code": {"type": "string", "minLength": 1}
+			}
+		},
+		"imageSet": {
+			"type": "object",
+			"required": ["uuid", "type", "video_id", "video_uuid", "members"],
+			"properties": {
+				"uuid": {"type": "string", "format": "uuid"},
+				"video_id": {"type": "string", "minLength": 1},
+				"video_uuid": {"type": "string", "format": "uuid"},
+				"members": {
+					"type": "array",
+					"minItems": 1,
+					"items": {
+						"type": "object",
+						"required": ["uuid", "type", "kind", "url"],
+						"properties": {
+							"uuid": {"type": "string", "format": "uuid"},
+							"type": {"const": "Image"},
+							"url": {"type": "string", "format": "uri"},
+							"width": {"type": "integer", "minimum": 0},
+							"height": {"type": "integer", "minimum": 0}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// payloadSchemaURL is the location the payload schema is compiled at, references relative to it resolve within the schema.
+const payloadSchemaURL = "payload-schema.json"
+
+type payloadValidation struct {
+	//mode is payloadValidationOff, payloadValidationWarn or payloadValidationReject
+	mode string
+	//file of the schema, empty for defaultPayloadSchema
+	file   string
+	schema *payloadSchema
+}
+
+func (pv payloadValidation) prettyPrint() string {
+	file := "built-in"
+	if pv.file != "" {
+		file = pv.file
+	}
+	var version string
+	if pv.schema != nil {
+		version = pv.schema.version
+	}
+	return fmt.Sprintf("mode: [%s], schema: [%s], version: [%s]", pv.mode, file, version)
+}
+
+// payloadSchema is a compiled JSON Schema, with its version from the version keyword, or else from $id.
+type payloadSchema struct {
+	version string
+	schema  *jsonschema.Schema
+}
+
+func loadPayloadSchema(file string) (*payloadSchema, error) {
+	data := []byte(defaultPayloadSchema)
+	if file != "" {
+		var err error
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsePayloadSchema(data)
+}
+
+func parsePayloadSchema(data []byte) (*payloadSchema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid payload schema: [%v]", err)
+	}
+	root, _ := doc.(map[string]interface{})
+	s := &payloadSchema{}
+	s.version, _ = root["version"].(string)
+	if s.version == "" {
+		s.version, _ = root["$id"].(string)
+	}
+	if s.version == "" {
+		return nil, fmt.Errorf("Invalid payload schema: no version nor $id.")
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.AssertFormat()
+	err = c.AddResource(payloadSchemaURL, doc)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid payload schema: [%v]", err)
+	}
+	s.schema, err = c.Compile(payloadSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid payload schema: [%v]", err)
+	}
+	return s, nil
+}
+
+// schemaViolation is a value of the payload not matching the schema, at the JSON pointer of the value.
+type schemaViolation struct {
+	//Payload is the uuid of the invalid payload, the video or one of its companion payloads
+	Payload string `json:"payload,omitempty"`
+	Path    string `json:"path"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// schemaValidationError lists the violations of the payloads.
+type schemaValidationError struct {
+	version    string
+	violations []schemaViolation
+}
+
+func (e *schemaValidationError) Error() string {
+	var msgs []string
+	for _, v := range e.violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Message))
+	}
+	return fmt.Sprintf("Payload does not match schema [%s]: %s", e.version, strings.Join(msgs, "; "))
+}
+
+// rejects tells whether payloads not matching the schema are kept from being forwarded.
+func (pv payloadValidation) rejects() bool {
+	return pv.mode == payloadValidationReject
+}
+
+// validatePayloads checks every payload delivered for the video against the schema, unless validation is off.
+// It returns a *schemaValidationError with the violations of all the invalid payloads.
+func (bn brightcoveNotifier) validatePayloads(payloads []video) error {
+	if bn.validation.mode == payloadValidationOff || bn.validation.schema == nil {
+		return nil
+	}
+	var violations []schemaViolation
+	for _, payload := range payloads {
+		err := bn.validation.schema.validate(payload)
+		vErr, ok := err.(*schemaValidationError)
+		if !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, v := range vErr.violations {
+			v.Payload, _ = payload["uuid"].(string)
+			violations = append(violations, v)
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &schemaValidationError{bn.validation.schema.version, violations}
+}
+
+// validate returns a *schemaValidationError if the payload doesn't match the schema.
+func (s *payloadSchema) validate(payload video) error {
+	// the payload is validated as JSON, e.g. the structs of the enrichments as objects
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	err = s.schema.Validate(doc)
+	vErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+	return &schemaValidationError{s.version, schemaViolations(vErr)}
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// schemaViolations flattens the validation error to its causes that failed on their own keyword.
+func schemaViolations(err *jsonschema.ValidationError) []schemaViolation {
+	if len(err.Causes) > 0 {
+		var violations []schemaViolation
+		for _, cause := range err.Causes {
+			violations = append(violations, schemaViolations(cause)...)
+		}
+		return violations
+	}
+	path := "/"
+	for i, token := range err.InstanceLocation {
+		if i > 0 {
+			path += "/"
+		}
+		path += jsonPointerEscaper.Replace(token)
+	}
+	var keyword string
+	if keywordPath := err.ErrorKind.KeywordPath(); len(keywordPath) > 0 {
+		keyword = keywordPath[len(keywordPath)-1]
+	}
+	var msg string
+	if out := err.BasicOutput(); out.Error != nil {
+		msg = out.Error.String()
+	}
+	return []schemaViolation{{Path: path, Keyword: keyword, Message: msg}}
+}
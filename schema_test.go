@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func buildTestPayload(t *testing.T, accID, videoID string) video {
+	var v video
+	if err := json.Unmarshal([]byte(buildTestVideoModel(accID, videoID)), &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := addUPPRequiredFields(v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDefaultPayloadSchema_VideoAndDeletedVideo_Valid(t *testing.T) {
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := buildTestPayload(t, "775205503001", "4020894387001")
+	v["captions"] = []caption{{Language: "en", Kind: "captions", URL: "https://example.com/en.vtt"}}
+	if err := schema.validate(v); err != nil {
+		t.Errorf("Expected valid video. Found: [%v]", err)
+	}
+	deleted := video{"error_code": "RESOURCE_NOT_FOUND", "message": "The resource you requested does not exist", "id": "4020894387001"}
+	if err := addUPPRequiredFields(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.validate(deleted); err != nil {
+		t.Errorf("Expected valid deleted video. Found: [%v]", err)
+	}
+	v["images"] = buildTestImages("cdn1.example.com")
+	if err := schema.validate(imageSet(v)); err != nil {
+		t.Errorf("Expected valid image set. Found: [%v]", err)
+	}
+}
+
+func TestDefaultPayloadSchema_InvalidVideo_AllViolationsReported(t *testing.T) {
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := buildTestPayload(t, "775205503001", "4020894387001")
+	delete(v, "name")
+	v["state"] = "BROKEN"
+	v["tags"] = []interface{}{"news", 3}
+	v["created_at"] = "yesterday"
+
+	err = schema.validate(v)
+	vErr, ok := err.(*schemaValidationError)
+	if !ok {
+		t.Fatalf("Expected schema validation error. Found: [%v]", err)
+	}
+	found := make(map[string]string)
+	for _, violation := range vErr.violations {
+		found[violation.Path] = violation.Keyword
+	}
+	expected := map[string]string{"/": "required", "/state": "enum", "/tags/1": "type", "/created_at": "format"}
+	for path, keyword := range expected {
+		if found[path] != keyword {
+			t.Errorf("Expected [%s] violation at [%s]. Found: %v", keyword, path, vErr.violations)
+		}
+	}
+	if !strings.Contains(err.Error(), "schema [v1]") {
+		t.Errorf("Expected schema version in the error. Found: [%v]", err)
+	}
+}
+
+func TestParsePayloadSchema_InvalidSchemas_Rejected(t *testing.T) {
+	invalid := map[string]string{
+		"not JSON":          `{"version": `,
+		"no version":        `{"type": "object"}`,
+		"unresolvable $ref": `{"version": "v2", "properties": {"id": {"$ref": "#/$defs/id"}}}`,
+		"invalid pattern":   `{"version": "v2", "properties": {"id": {"pattern": "("}}}`,
+		"invalid type":      `{"version": "v2", "type": "video"}`,
+	}
+	for name, schema := range invalid {
+		if _, err := parsePayloadSchema([]byte(schema)); err == nil {
+			t.Errorf("Expected error for [%s].", name)
+		}
+	}
+	s, err := parsePayloadSchema([]byte(`{
+		"$id": "https://example.com/video/v2.json",
+		"properties": {"id": {"$ref": "#/$defs/id"}, "state": {"not": {"const": "DELETED"}}},
+		"oneOf": [{"required": ["name"]}, {"required": ["error_code"]}],
+		"$defs": {"id": {"type": "string", "pattern": "^\\d+$"}}
+	}`))
+	if err != nil || s.version != "https://example.com/video/v2.json" {
+		t.Fatalf("Expected schema versioned by $id. Found: [%v] [%v]", s, err)
+	}
+	if err := s.validate(video{"id": "4020894387001", "name": "Sea"}); err != nil {
+		t.Errorf("Expected valid video. Found: [%v]", err)
+	}
+	err = s.validate(video{"id": "ref:abc", "state": "DELETED", "name": "Sea", "error_code": "RESOURCE_NOT_FOUND"})
+	vErr, ok := err.(*schemaValidationError)
+	if !ok || len(vErr.violations) != 3 {
+		t.Errorf("Expected pattern, not and oneOf violations. Found: [%v]", err)
+	}
+}
+
+func TestPublish_InvalidPayload_RejectedCountedAndKeptInFailures(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(strings.Replace(buildTestVideoModel(accID, videoID), `"ACTIVE"`, `"BROKEN"`, 1)))
+		case "/cms-notifier/notify":
+			forwards++
+		}
+	}))
+	defer ts.Close()
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		failures:        newFailureStore(10),
+		validation:      payloadValidation{mode: payloadValidationReject, schema: schema},
+	}
+	invalid := func() int64 {
+		if v, ok := pipelineMetrics.Get(metricPayloadInvalid).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := invalid()
+
+	_, err = bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{Video: videoID}))
+	if pErr, ok := err.(*pipelineError); !ok || pErr.stage != stageValidate || bn.pipelineErrorStatus(err) != http.StatusBadRequest {
+		t.Fatalf("Expected validate stage error. Found: [%v]", err)
+	}
+	if forwards != 0 {
+		t.Errorf("Expected invalid payload not forwarded. Found [%d] forwards.", forwards)
+	}
+	if invalid() != before+1 {
+		t.Errorf("Expected invalid payload counted. Found: [%d]", invalid()-before)
+	}
+	f, found := bn.failures.get("tid_test")
+	if !found || len(f.Violations) != 1 || f.Violations[0].Path != "/state" {
+		t.Errorf("Expected failure with the violation. Found: [%+v]", f)
+	}
+}
+
+func TestPublish_InvalidImageSet_RejectedBeforeAnyDelivery(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			var v video
+			_ = json.Unmarshal([]byte(buildTestVideoModel(accID, videoID)), &v)
+			v["images"] = map[string]interface{}{"poster": map[string]interface{}{"src": "/poster.jpg"}}
+			_ = json.NewEncoder(w).Encode(v)
+		case "/cms-notifier/notify":
+			forwards++
+		}
+	}))
+	defer ts.Close()
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		failures:        newFailureStore(10),
+		imageSets:       true,
+		validation:      payloadValidation{mode: payloadValidationReject, schema: schema},
+	}
+
+	_, err = bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{Video: videoID}))
+	if pErr, ok := err.(*pipelineError); !ok || pErr.stage != stageValidate {
+		t.Fatalf("Expected validate stage error. Found: [%v]", err)
+	}
+	if forwards != 0 {
+		t.Errorf("Expected neither the image set nor the video forwarded. Found [%d] forwards.", forwards)
+	}
+	f, _ := bn.failures.get("tid_test")
+	if len(f.Violations) != 1 || f.Violations[0].Payload != imageSetUUID(videoID) || f.Violations[0].Path != "/members/0/url" {
+		t.Errorf("Expected the violation of the image set. Found: [%+v]", f.Violations)
+	}
+}
+
+func TestPublish_DeletedVideoUnderRejectValidation_Forwarded(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	var forwarded []video
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`[{"error_code":"RESOURCE_NOT_FOUND","message":"The resource you requested does not exist"}]`))
+		case "/cms-notifier/notify":
+			var v video
+			_ = json.NewDecoder(r.Body).Decode(&v)
+			forwarded = append(forwarded, v)
+		}
+	}))
+	defer ts.Close()
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		failures:        newFailureStore(10),
+		imageSets:       true,
+		validation:      payloadValidation{mode: payloadValidationReject, schema: schema},
+	}
+
+	_, err = bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, newHistoryEntry("tid_test", videoEvent{Video: videoID}))
+	if err != nil {
+		t.Fatalf("Expected the delete forwarded. Found: [%v]", err)
+	}
+	if len(forwarded) != 1 || forwarded[0]["error_code"] != "RESOURCE_NOT_FOUND" || forwarded[0]["id"] != videoID {
+		t.Errorf("Expected the deleted video forwarded. Found: [%v]", forwarded)
+	}
+}
+
+func TestPublish_InvalidPayloadUnderWarnValidation_ForwardedWithWarning(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(strings.Replace(buildTestVideoModel(accID, videoID), `"ACTIVE"`, `"BROKEN"`, 1)))
+		case "/cms-notifier/notify":
+			forwards++
+		}
+	}))
+	defer ts.Close()
+	schema, err := loadPayloadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+		failures:        newFailureStore(10),
+		validation:      payloadValidation{mode: payloadValidationWarn, schema: schema},
+	}
+	entry := newHistoryEntry("tid_test", videoEvent{Video: videoID})
+
+	_, err = bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, entry)
+	if err != nil {
+		t.Fatalf("Expected the invalid payload forwarded. Found: [%v]", err)
+	}
+	if forwards != 1 {
+		t.Errorf("Expected one forward. Found [%d].", forwards)
+	}
+	if len(entry.Warnings) != 1 || !strings.Contains(entry.Warnings[0], "/state") {
+		t.Errorf("Expected the violation as a warning. Found: %v", entry.Warnings)
+	}
+	if _, found := bn.failures.get("tid_test"); found {
+		t.Error("Expected no failure kept.")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretProvider resolves secret references, e.g. file:/run/secrets/brightcove-auth.
+// Providers are registered in secretProviders by the scheme of their references.
+type secretProvider interface {
+	secret(ref string) (string, error)
+}
+
+// fileSecretProvider reads secrets from files, e.g. mounted secret volumes.
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) secret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+var secretProviders = map[string]secretProvider{
+	"file": fileSecretProvider{},
+}
+
+// isSecretRef tells if the value is a reference to a secret rather than the secret itself.
+func isSecretRef(value string) bool {
+	scheme, _, found := strings.Cut(value, ":")
+	_, registered := secretProviders[scheme]
+	return found && registered
+}
+
+// resolveSecret returns the secret the value refers to, or the value itself if it isn't a reference.
+func resolveSecret(value string) (string, error) {
+	if !isSecretRef(value) {
+		return value, nil
+	}
+	scheme, ref, _ := strings.Cut(value, ":")
+	secret, err := secretProviders[scheme].secret(ref)
+	if err != nil {
+		return "", fmt.Errorf("Could not read secret [%s]: [%v]", value, err)
+	}
+	return secret, nil
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// credentialOpts are the options holding the credentials of the upstreams, or references to them.
+type credentialOpts struct {
+	brightcoveAuth         *string
+	brightcoveClientID     *string
+	brightcoveClientSecret *string
+	cmsNotifierAuth        *string
+}
+
+// credentials are the authorization headers of the upstreams.
+type credentials struct {
+	brightcoveAuth  string
+	cmsNotifierAuth string
+}
+
+// resolve reads the credentials. The Brightcove client id and secret, if given, take precedence over the brightcove-auth header.
+func (co credentialOpts) resolve() (credentials, error) {
+	var creds credentials
+	values := make(map[*string]string)
+	for _, opt := range []*string{co.brightcoveAuth, co.brightcoveClientID, co.brightcoveClientSecret, co.cmsNotifierAuth} {
+		value, err := resolveSecret(*opt)
+		if err != nil {
+			return creds, err
+		}
+		values[opt] = value
+	}
+	clientID, clientSecret := values[co.brightcoveClientID], values[co.brightcoveClientSecret]
+	switch {
+	case clientID != "" && clientSecret != "":
+		creds.brightcoveAuth = basicAuth(clientID, clientSecret)
+	case clientID != "" || clientSecret != "":
+		return creds, fmt.Errorf("Both brightcove-client-id and brightcove-client-secret are needed.")
+	default:
+		creds.brightcoveAuth = values[co.brightcoveAuth]
+	}
+	creds.cmsNotifierAuth = values[co.cmsNotifierAuth]
+	return creds, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSecret(t *testing.T, dir, name, secret string) string {
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCredentials_ClientIDAndSecretFromFiles_BuildsBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	clientID := "clientId"
+	clientSecret := "file:" + writeSecret(t, dir, "client-secret", "clientSecret")
+	auth := "Basic ignored"
+	cmsNotifierAuth := "file:" + writeSecret(t, dir, "cms-notifier-auth", "Basic dXB")
+	creds, err := credentialOpts{&auth, &clientID, &clientSecret, &cmsNotifierAuth}.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.brightcoveAuth != "Basic Y2xpZW50SWQ6Y2xpZW50U2VjcmV0" {
+		t.Errorf("Expected Basic header built from the client id and secret. Found: [%s]", creds.brightcoveAuth)
+	}
+	if creds.cmsNotifierAuth != "Basic dXB" {
+		t.Errorf("Expected CMS Notifier auth read from file. Found: [%s]", creds.cmsNotifierAuth)
+	}
+}
+
+func TestCredentials_ClientSecretWithoutID_Fails(t *testing.T) {
+	auth, clientID, clientSecret, cmsNotifierAuth := "", "", "clientSecret", ""
+	_, err := credentialOpts{&auth, &clientID, &clientSecret, &cmsNotifierAuth}.resolve()
+	if err == nil {
+		t.Error("Expected error without client id.")
+	}
+}
+
+func TestRefresh_SecretRotated_DropsAccessToken(t *testing.T) {
+	file := writeSecret(t, t.TempDir(), "brightcove-auth", "Basic old")
+	auth, clientID, clientSecret, cmsNotifierAuth := "file:"+file, "", "", ""
+	credOpts := credentialOpts{&auth, &clientID, &clientSecret, &cmsNotifierAuth}
+	bn := &brightcoveNotifier{brightcoveConf: &brightcoveConfig{auth: "Basic old", accessToken: "token"}}
+	rl := &reloader{swap: func() error {
+		creds, err := credOpts.resolve()
+		if err != nil {
+			return err
+		}
+		bn.brightcoveConf.update("", "", creds.brightcoveAuth, "")
+		return nil
+	}}
+
+	writeSecret(t, filepath.Dir(file), "brightcove-auth", "Basic new")
+	if err := rl.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, a := bn.brightcoveConf.oauthRequest(); a != "Basic new" {
+		t.Errorf("Expected rotated secret. Found: [%s]", a)
+	}
+	if _, a := bn.brightcoveConf.apiRequest(""); a != "Bearer " {
+		t.Errorf("Expected access token dropped. Found: [%s]", a)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type shutdownConfig struct {
+	//delay between failing /__gtg and closing the listener, so load balancers stop sending traffic first
+	delay time.Duration
+	//timeout for draining the in-flight notifications
+	timeout time.Duration
+}
+
+func (sc shutdownConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tdelay: [%v]\n\t\ttimeout: [%v]\n\t", sc.delay, sc.timeout)
+}
+
+// lifecycle tracks the accepted work, so shutdown can wait for all of it to finish.
+// A nil *lifecycle accepts everything and tracks nothing.
+type lifecycle struct {
+	sync.Mutex
+	stopping bool
+	work     sync.WaitGroup
+	//ctx is the base context of the requests, cancelled when draining them times out
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+func (l *lifecycle) baseContext(net.Listener) context.Context {
+	return l.context()
+}
+
+func (l *lifecycle) context() context.Context {
+	if l == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// accept registers a unit of work, unless shutdown has started. Accepted work must call done.
+func (l *lifecycle) accept() bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.stopping {
+		return false
+	}
+	l.work.Add(1)
+	return true
+}
+
+func (l *lifecycle) done() {
+	if l == nil {
+		return
+	}
+	l.work.Done()
+}
+
+func (l *lifecycle) isStopping() bool {
+	if l == nil {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.stopping
+}
+
+func (l *lifecycle) stop() {
+	l.Lock()
+	defer l.Unlock()
+	l.stopping = true
+}
+
+// wait blocks until the accepted work is done or the context expires.
+func (l *lifecycle) wait(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		l.work.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tracked rejects the request with 503 once shutdown started, otherwise serves it as accepted work.
+// Brightcove retries notifications failing with 5xx, so rejected events are delivered to another node.
+func (bn brightcoveNotifier) tracked(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bn.lifecycle.accept() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		defer bn.lifecycle.done()
+		h(w, r)
+	}
+}
+
+// shutdown stops accepting notifications, fails /__gtg, then drains the in-flight requests and the accepted work,
+// then stops the deliveries to the subscribers.
+func (bn brightcoveNotifier) shutdown(server *http.Server) {
+	bn.lifecycle.stop()
+	bn.healthCache.stop()
+	bn.ingest.stop()
+	//the subscribers are notified by the accepted work, so they are stopped once it is drained
+	defer bn.subscribers.stop()
+	infoLogger.Printf("Shutting down: no more notifications accepted. Waiting [%v] before closing the listener.", bn.shutdownConf.delay)
+	time.Sleep(bn.shutdownConf.delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), bn.shutdownConf.timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		warnLogger.Printf("In-flight requests were not drained: [%v]", err)
+	}
+	err = bn.lifecycle.wait(ctx)
+	if err != nil {
+		warnLogger.Printf("Accepted work was not drained: [%v]. Cancelling it.", err)
+		bn.lifecycle.cancel()
+		return
+	}
+	infoLogger.Println("In-flight notifications drained.")
+}
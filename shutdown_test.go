@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdown_NotificationInFlight_NotificationIsForwardedBeforeShutdownCompletes(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	fetchStarted := make(chan struct{})
+	releaseFetch := make(chan struct{})
+	var forwards int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			close(fetchStarted)
+			<-releaseFetch
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			atomic.AddInt32(&forwards, 1)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: upstream.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: upstream.URL + "/cms-notifier"},
+		lifecycle:       newLifecycle(),
+		shutdownConf:    shutdownConfig{delay: 100 * time.Millisecond, timeout: 5 * time.Second},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[%v]", err)
+	}
+	server := &http.Server{Handler: bn.router()}
+	go server.Serve(l)
+
+	statusCodes := make(chan int, 1)
+	go func() {
+		res, err := http.Post("http://"+l.Addr().String()+"/notify", "application/json", strings.NewReader(buildTestVideoEvent(accID, videoID)))
+		if err != nil {
+			statusCodes <- 0
+			return
+		}
+		statusCodes <- res.StatusCode
+	}()
+	<-fetchStarted
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		bn.shutdown(server)
+		close(shutdownDone)
+	}()
+	for !bn.lifecycle.isStopping() {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("GET", "/__gtg", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /__gtg to fail once shutdown started. Received status code: [%d]", w.Code)
+	}
+	w = httptest.NewRecorder()
+	bn.router().ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(buildTestVideoEvent(accID, videoID))))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected new notifications to be rejected once shutdown started. Received status code: [%d]", w.Code)
+	}
+
+	time.Sleep(2 * bn.shutdownConf.delay)
+	select {
+	case <-shutdownDone:
+		t.Fatal("Expected shutdown to wait for the in-flight notification.")
+	default:
+	}
+	close(releaseFetch)
+
+	if status := <-statusCodes; status != http.StatusOK {
+		t.Fatalf("Expected in-flight notification to succeed. Received status code: [%d]", status)
+	}
+	<-shutdownDone
+	if atomic.LoadInt32(&forwards) != 1 {
+		t.Fatalf("Expected in-flight notification to be forwarded. Forwarded: [%d]", forwards)
+	}
+}
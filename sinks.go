@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sinkCMSNotifier = "cms-notifier"
+	sinkKafka       = "kafka"
+	sinkWebhook     = "webhook"
+	sinkFile        = "file"
+
+	deliveryStatusSuccess = "success"
+	deliveryStatusFailed  = "failed"
+
+	//maxResponseBody is the length of the response bodies kept in the deliveries and their errors
+	maxResponseBody = 1024
+)
+
+var sinkNames = []string{sinkCMSNotifier, sinkKafka, sinkWebhook, sinkFile}
+
+// sink is an output the UPP payloads are delivered to.
+// deliver returns the response of the sink to the payload, e.g. the status and body of the HTTP sinks, or empty if it answers none.
+type sink interface {
+	name() string
+	deliver(ctx context.Context, video video, tid string) (string, error)
+}
+
+type sinkConfig struct {
+	//names of the sinks the payloads are fanned out to
+	names      []string
+	kafka      kafkaConfig
+	webhookURL string
+	//file the payloads are appended to, - for stdout
+	file string
+	//fileWriter is shared by the deliveries to the file sink
+	fileWriter *lineWriter
+}
+
+func (sc sinkConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\tnames: %v\n\t\tkafka: [%s]\n\t\twebhookURL: [%s]\n\t\tfile: [%s]\n\t",
+		sc.names, sc.kafka.prettyPrint(), sc.webhookURL, sc.file)
+}
+
+func (sc sinkConfig) validate() error {
+	for _, name := range sc.names {
+		switch name {
+		case sinkCMSNotifier:
+		case sinkKafka:
+			if sc.kafka.proxyAddr == "" || sc.kafka.topic == "" {
+				return fmt.Errorf("The kafka sink needs kafka-proxy and kafka-topic.")
+			}
+		case sinkWebhook:
+			if sc.webhookURL == "" {
+				return fmt.Errorf("The webhook sink needs webhook-url.")
+			}
+		case sinkFile:
+			if sc.file == "" {
+				return fmt.Errorf("The file sink needs sink-file.")
+			}
+		default:
+			return fmt.Errorf("Unknown sink: [%s]. Expected one of %v", name, sinkNames)
+		}
+	}
+	return nil
+}
+
+// sinks returns the configured sinks, or the CMS Notifier only if none is configured.
+func (bn brightcoveNotifier) sinks() []sink {
+	if len(bn.sinkConf.names) == 0 {
+		return []sink{cmsNotifierSink{bn}}
+	}
+	var sinks []sink
+	for _, name := range bn.sinkConf.names {
+		switch name {
+		case sinkCMSNotifier:
+			sinks = append(sinks, cmsNotifierSink{bn})
+		case sinkKafka:
+			sinks = append(sinks, kafkaSink{bn, bn.sinkConf.kafka})
+		case sinkWebhook:
+			sinks = append(sinks, webhookSink{bn, bn.sinkConf.webhookURL})
+		case sinkFile:
+			sinks = append(sinks, fileSink{bn.sinkConf.fileWriter})
+		}
+	}
+	return sinks
+}
+
+// delivery is the outcome of delivering a payload to one sink.
+type delivery struct {
+	Sink   string `json:"sink"`
+	Status string `json:"status"`
+	//Response of the sink to the last payload delivered
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+	err      error
+}
+
+// deliveryError lists the sinks a payload could not be delivered to.
+type deliveryError struct {
+	failed map[string]error
+}
+
+func (e *deliveryError) Error() string {
+	var msgs []string
+	for _, name := range e.sinks() {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e.failed[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is match the errors of the sinks, e.g. context.DeadlineExceeded.
+func (e *deliveryError) Unwrap() []error {
+	var errs []error
+	for _, name := range e.sinks() {
+		errs = append(errs, e.failed[name])
+	}
+	return errs
+}
+
+func (e *deliveryError) sinks() []string {
+	var names []string
+	for name := range e.failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deliver fans the payloads out to the sinks in parallel, or to the given ones only if any.
+// Each sink gets the payloads in order, the video last after its companion payloads, and stops at the first failure.
+// It returns a *deliveryError if any delivery failed.
+func (bn brightcoveNotifier) deliver(ctx context.Context, payloads []video, tid string, only []string) ([]delivery, error) {
+	video := payloads[len(payloads)-1]
+	var selected []sink
+	for _, s := range bn.sinks() {
+		if len(only) == 0 || contains(only, s.name()) {
+			selected = append(selected, s)
+		}
+	}
+	deliveries := make([]delivery, len(selected))
+	var wg sync.WaitGroup
+	for i, s := range selected {
+		wg.Add(1)
+		go func(i int, s sink) {
+			defer wg.Done()
+			d := delivery{Sink: s.name(), Status: deliveryStatusSuccess}
+			for _, payload := range payloads {
+				response, err := s.deliver(ctx, payload, tid)
+				d.Response = response
+				if err != nil {
+					d.Status, d.Error, d.err = deliveryStatusFailed, err.Error(), err
+					break
+				}
+			}
+			deliveries[i] = d
+		}(i, s)
+	}
+	wg.Wait()
+
+	failed := make(map[string]error)
+	for _, d := range deliveries {
+		if d.err != nil {
+			incMetric(metricForwardFailure + "." + d.Sink)
+			warnLogger.Printf("tid=%v video_id=%v sink=%s Delivery unsuccessful: [%v]", tid, video["id"], d.Sink, d.err)
+			failed[d.Sink] = d.err
+			continue
+		}
+		incMetric(metricForwardSuccess + "." + d.Sink)
+	}
+	if len(failed) > 0 {
+		return deliveries, &deliveryError{failed}
+	}
+	return deliveries, nil
+}
+
+// cmsNotifierSink posts the payloads to the CMS Notifier.
+type cmsNotifierSink struct {
+	bn brightcoveNotifier
+}
+
+func (s cmsNotifierSink) name() string {
+	return sinkCMSNotifier
+}
+
+func (s cmsNotifierSink) deliver(ctx context.Context, video video, tid string) (string, error) {
+	return s.bn.fwdVideo(ctx, video, tid)
+}
+
+// webhookSink posts the payloads to any HTTP endpoint.
+type webhookSink struct {
+	bn  brightcoveNotifier
+	url string
+}
+
+func (s webhookSink) name() string {
+	return sinkWebhook
+}
+
+func (s webhookSink) deliver(ctx context.Context, video video, tid string) (string, error) {
+	body, err := json.Marshal(video)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Origin-System-Id", "brightcove")
+	req.Header.Add("X-Request-Id", tid)
+	injectTraceContext(ctx, req)
+	return expectSuccess(s.bn.do(upstreamWebhook, req))
+}
+
+// expectSuccess returns the summary of the response, and fails on responses other than 2xx.
+func expectSuccess(resp *http.Response, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer cleanupResp(resp)
+	body := responseBody(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseSummary(resp, body), fmt.Errorf("Invalid statusCode received: [%d] [%s]", resp.StatusCode, body)
+	}
+	return responseSummary(resp, body), nil
+}
+
+// responseBody reads the start of the response body, up to maxResponseBody.
+func responseBody(resp *http.Response) string {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return strings.TrimSpace(string(msg))
+}
+
+// responseSummary is the status of the response followed by the start of its body, if any.
+func responseSummary(resp *http.Response, body string) string {
+	if body == "" {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s [%s]", resp.Status, body)
+}
+
+// fileSink writes the payloads as JSON lines, for testing.
+type fileSink struct {
+	w *lineWriter
+}
+
+func (s fileSink) name() string {
+	return sinkFile
+}
+
+type fileSinkLine struct {
+	TransactionID string    `json:"transaction_id"`
+	DeliveredAt   time.Time `json:"delivered_at"`
+	Payload       video     `json:"payload"`
+}
+
+func (s fileSink) deliver(ctx context.Context, video video, tid string) (string, error) {
+	return "", s.w.writeJSON(fileSinkLine{tid, time.Now().UTC(), video})
+}
+
+// lineWriter appends JSON lines to a file, or to stdout.
+type lineWriter struct {
+	sync.Mutex
+	file string
+}
+
+func newLineWriter(file string) *lineWriter {
+	return &lineWriter{file: file}
+}
+
+func (lw *lineWriter) writeJSON(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	lw.Lock()
+	defer lw.Unlock()
+	if lw.file == "-" {
+		_, err = os.Stdout.Write(append(line, '\n'))
+		return err
+	}
+	f, err := os.OpenFile(lw.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
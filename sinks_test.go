@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublish_WebhookSinkFails_OtherSinksDeliveredAndOnlyWebhookReplayed(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	webhookUp := false
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webhookUp {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer webhook.Close()
+	file := filepath.Join(t.TempDir(), "payloads.jsonl")
+	bn := &brightcoveNotifier{
+		client:   &http.Client{},
+		failures: newFailureStore(10),
+		sinkConf: sinkConfig{
+			names:      []string{sinkCMSNotifier, sinkWebhook, sinkFile},
+			webhookURL: webhook.URL,
+			fileWriter: newLineWriter(file),
+		},
+	}
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	entry := newHistoryEntry("tid_test", videoEvent{Video: videoID})
+	_, err := bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, entry)
+	if err == nil {
+		t.Fatal("Expected delivery error.")
+	}
+	statuses := make(map[string]string)
+	for _, d := range entry.Deliveries {
+		statuses[d.Sink] = d.Status
+	}
+	expected := map[string]string{sinkCMSNotifier: deliveryStatusSuccess, sinkWebhook: deliveryStatusFailed, sinkFile: deliveryStatusSuccess}
+	for sink, status := range expected {
+		if statuses[sink] != status {
+			t.Errorf("Expected [%s] delivery [%s]. Found: [%s]", sink, status, statuses[sink])
+		}
+	}
+	if entry.CMSResponse != "200 OK" {
+		t.Errorf("Expected the CMS Notifier response recorded. Found: [%s]", entry.CMSResponse)
+	}
+
+	f, found := bn.failures.get("tid_test")
+	if !found || len(f.Sinks) != 1 || f.Sinks[0] != sinkWebhook {
+		t.Fatalf("Expected failure of the webhook sink only. Found: [%+v]", f)
+	}
+	webhookUp = true
+	if result := bn.replay(context.Background(), f); !result.Success {
+		t.Fatalf("Expected replay to succeed. Found: [%v]", result.Error)
+	}
+	if forwards != 1 {
+		t.Errorf("Expected CMS Notifier not to be called again on replay. Found [%d] forwards.", forwards)
+	}
+}
+
+func TestPublish_CMSNotifierAnswers_ResponseRecordedInHistory(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	status, body := http.StatusOK, `{"message": "queued"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+	}
+
+	entry := newHistoryEntry("tid_test", videoEvent{Video: videoID})
+	if _, err := bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{}, entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.CMSResponse != `200 OK [{"message": "queued"}]` || entry.Deliveries[0].Response != entry.CMSResponse {
+		t.Errorf("Expected the response of the CMS Notifier recorded. Found: [%s] [%+v]", entry.CMSResponse, entry.Deliveries)
+	}
+
+	status, body = http.StatusBadRequest, strings.Repeat("x", 2*maxResponseBody)
+	entry = newHistoryEntry("tid_test", videoEvent{Video: videoID})
+	if _, err := bn.publish(context.Background(), videoEvent{Video: videoID}, "tid_test", publishOptions{force: true}, entry); err == nil {
+		t.Fatal("Expected delivery error.")
+	}
+	if expected := "400 Bad Request [" + body[:maxResponseBody] + "]"; entry.CMSResponse != expected {
+		t.Errorf("Expected the truncated response of the CMS Notifier recorded. Found: [%s]", entry.CMSResponse)
+	}
+}
+
+func TestFileSink_Deliver_PayloadWrittenAsJSONLine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "payloads.jsonl")
+	s := fileSink{newLineWriter(file)}
+	for _, id := range []string{"1", "2"} {
+		if _, err := s.deliver(context.Background(), video{"id": id}, "tid_"+id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []fileSinkLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line fileSinkLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[1].TransactionID != "tid_2" || lines[1].Payload["id"] != "2" {
+		t.Errorf("Expected 2 payload lines. Found: [%+v]", lines)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+const (
+	signatureHeader = "X-Signature-256"
+
+	webhookStatusDelivered = "delivered"
+	webhookStatusRetrying  = "retrying"
+	webhookStatusFailed    = "failed"
+	webhookStatusDropped   = "dropped"
+)
+
+type webhookConfig struct {
+	//optional JSON file the subscribers are loaded from, and saved to when changed through the admin API
+	file string
+	//attempts of a delivery before giving up on it
+	maxAttempts  int
+	retryBackoff time.Duration
+	//deliveries waiting per subscriber, the next ones are dropped
+	queueSize int
+	//delivery attempts kept per subscriber
+	logSize int
+}
+
+func (wc webhookConfig) prettyPrint() string {
+	file := "in-memory only"
+	if wc.file != "" {
+		file = wc.file
+	}
+	return fmt.Sprintf("\n\t\tfile: [%s]\n\t\tmaxAttempts: [%d]\n\t\tretryBackoff: [%v]\n\t\tqueueSize: [%d]\n\t\tlogSize: [%d]\n\t",
+		file, wc.maxAttempts, wc.retryBackoff, wc.queueSize, wc.logSize)
+}
+
+// subscriberFilter selects the videos a subscriber is notified about. Empty criteria match everything,
+// otherwise the video has to match one of the values of every criterion.
+type subscriberFilter struct {
+	//Events are Brightcove event types, e.g. video-change
+	Events []string `json:"events,omitempty"`
+	States []string `json:"states,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func (sf subscriberFilter) matches(event videoEvent, video video) bool {
+	if len(sf.Events) > 0 && !contains(sf.Events, event.Event) {
+		return false
+	}
+	if state, _ := video["state"].(string); len(sf.States) > 0 && !contains(sf.States, state) {
+		return false
+	}
+	if len(sf.Tags) == 0 {
+		return true
+	}
+	tags, _ := video["tags"].([]interface{})
+	for _, tag := range tags {
+		if s, ok := tag.(string); ok && contains(sf.Tags, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriber is a downstream webhook notified of the processed video payloads.
+type subscriber struct {
+	ID     string           `json:"id"`
+	URL    string           `json:"url"`
+	Secret string           `json:"secret,omitempty"`
+	Filter subscriberFilter `json:"filter"`
+}
+
+func (s subscriber) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid subscriber url: [%s]", s.URL)
+	}
+	return nil
+}
+
+// subscriberView is a subscriber as listed by the admin API, without its secret.
+type subscriberView struct {
+	ID      string           `json:"id"`
+	URL     string           `json:"url"`
+	Signed  bool             `json:"signed"`
+	Filter  subscriberFilter `json:"filter"`
+	Pending int              `json:"pending"`
+}
+
+// webhookAttempt is an entry of the delivery log of a subscriber.
+type webhookAttempt struct {
+	DeliveryID    string    `json:"delivery_id"`
+	TransactionID string    `json:"transaction_id"`
+	UUID          string    `json:"uuid"`
+	Attempt       int       `json:"attempt"`
+	Status        string    `json:"status"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+type webhookDelivery struct {
+	id    string
+	tid   string
+	event videoEvent
+	uuid  string
+	body  []byte
+}
+
+// subscription is a registered subscriber with its retry queue, drained by its own worker in order.
+type subscription struct {
+	subscriber
+	queue chan webhookDelivery
+	//ctx is cancelled, under the lock of the dispatcher, once the subscription is replaced or removed:
+	//its worker stops, its delivery in flight is aborted and it logs nothing more
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    []webhookAttempt
+}
+
+// webhookDispatcher keeps the registry of subscribers and delivers the payloads to them.
+// A nil *webhookDispatcher has no subscribers.
+type webhookDispatcher struct {
+	sync.Mutex
+	conf          webhookConfig
+	subscriptions map[string]*subscription
+	//post sends the requests to the subscribers
+	post func(req *http.Request) (*http.Response, error)
+}
+
+func newWebhookDispatcher(conf webhookConfig, post func(req *http.Request) (*http.Response, error)) (*webhookDispatcher, error) {
+	wd := &webhookDispatcher{conf: conf, subscriptions: make(map[string]*subscription), post: post}
+	if conf.file == "" {
+		return wd, nil
+	}
+	data, err := os.ReadFile(conf.file)
+	if os.IsNotExist(err) {
+		return wd, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var subscribers []subscriber
+	err = json.Unmarshal(data, &subscribers)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid subscribers file [%s]: [%v]", conf.file, err)
+	}
+	for _, s := range subscribers {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		wd.subscribe(s)
+	}
+	return wd, nil
+}
+
+// subscribe registers the subscriber, replacing the one with the same id if any. The worker of the replaced subscriber
+// is stopped before the new one starts: the delivery log is kept, the deliveries queued for the replaced subscriber are dropped.
+func (wd *webhookDispatcher) subscribe(s subscriber) {
+	sub := &subscription{
+		subscriber: s,
+		queue:      make(chan webhookDelivery, wd.conf.queueSize),
+	}
+	sub.ctx, sub.cancel = context.WithCancel(context.Background())
+	if old, found := wd.subscriptions[s.ID]; found {
+		old.cancel()
+		sub.log = old.log
+	}
+	wd.subscriptions[s.ID] = sub
+	go wd.work(sub)
+}
+
+func (wd *webhookDispatcher) unsubscribe(id string) bool {
+	sub, found := wd.subscriptions[id]
+	if !found {
+		return false
+	}
+	sub.cancel()
+	delete(wd.subscriptions, id)
+	return true
+}
+
+// notify queues the delivery of the payload to the subscribers whose filter matches.
+func (wd *webhookDispatcher) notify(event videoEvent, video video, tid string) {
+	if wd == nil {
+		return
+	}
+	body, err := json.Marshal(video)
+	if err != nil {
+		warnLogger.Printf("tid=%v Could not notify subscribers: [%v]", tid, err)
+		return
+	}
+	wd.Lock()
+	defer wd.Unlock()
+	for _, sub := range wd.subscriptions {
+		if !sub.Filter.matches(event, video) {
+			continue
+		}
+		d := webhookDelivery{id: uuid.NewRandom().String(), tid: tid, event: event, body: body}
+		d.uuid, _ = video["uuid"].(string)
+		select {
+		case sub.queue <- d:
+		default:
+			warnLogger.Printf("tid=%v subscriber=%s Delivery queue full, dropping delivery.", tid, sub.ID)
+			wd.logAttempt(sub, d, 0, webhookStatusDropped, 0, fmt.Errorf("Queue full."))
+		}
+	}
+}
+
+// work delivers the queued payloads to the subscriber in order, retrying each one with exponential backoff.
+func (wd *webhookDispatcher) work(sub *subscription) {
+	for {
+		select {
+		case <-sub.ctx.Done():
+			return
+		case d := <-sub.queue:
+			if sub.ctx.Err() != nil {
+				return
+			}
+			backoff := wd.conf.retryBackoff
+			for attempt := 1; ; attempt++ {
+				code, err := wd.send(sub.ctx, sub.subscriber, d)
+				status := webhookStatusDelivered
+				switch {
+				case err != nil && attempt >= wd.conf.maxAttempts:
+					status = webhookStatusFailed
+					warnLogger.Printf("tid=%v subscriber=%s Delivery failed after [%d] attempts: [%v]", d.tid, sub.ID, attempt, err)
+				case err != nil:
+					status = webhookStatusRetrying
+				}
+				wd.Lock()
+				if sub.ctx.Err() != nil {
+					//replaced or removed meanwhile, the log now belongs to the new subscription
+					wd.Unlock()
+					return
+				}
+				wd.logAttempt(sub, d, attempt, status, code, err)
+				wd.Unlock()
+				if status != webhookStatusRetrying {
+					break
+				}
+				select {
+				case <-sub.ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (wd *webhookDispatcher) send(ctx context.Context, s subscriber, d webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Origin-System-Id", "brightcove")
+	req.Header.Add("X-Request-Id", d.tid)
+	req.Header.Add("X-Delivery-Id", d.id)
+	req.Header.Add("X-Event-Type", d.event.Event)
+	if s.Secret != "" {
+		req.Header.Add(signatureHeader, sign(s.Secret, d.body))
+	}
+	resp, err := wd.post(req)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanupResp(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Invalid statusCode received: [%d]", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body, prefixed by sha256=.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wd *webhookDispatcher) logAttempt(sub *subscription, d webhookDelivery, attempt int, status string, code int, err error) {
+	a := webhookAttempt{DeliveryID: d.id, TransactionID: d.tid, UUID: d.uuid, Attempt: attempt, Status: status, StatusCode: code, At: time.Now().UTC()}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	sub.log = append(sub.log, a)
+	if len(sub.log) > wd.conf.logSize {
+		sub.log = sub.log[len(sub.log)-wd.conf.logSize:]
+	}
+}
+
+// stop stops the workers. The deliveries still queued are lost.
+func (wd *webhookDispatcher) stop() {
+	if wd == nil {
+		return
+	}
+	wd.Lock()
+	defer wd.Unlock()
+	for id, sub := range wd.subscriptions {
+		if pending := len(sub.queue); pending > 0 {
+			warnLogger.Printf("subscriber=%s Dropping [%d] queued deliveries.", id, pending)
+		}
+		sub.cancel()
+	}
+	wd.subscriptions = make(map[string]*subscription)
+}
+
+// save writes the subscribers to the file, if any.
+func (wd *webhookDispatcher) save() error {
+	if wd.conf.file == "" {
+		return nil
+	}
+	subscribers := []subscriber{}
+	for _, sub := range wd.subscriptions {
+		subscribers = append(subscribers, sub.subscriber)
+	}
+	sort.Slice(subscribers, func(i, j int) bool { return subscribers[i].ID < subscribers[j].ID })
+	data, err := json.MarshalIndent(subscribers, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := wd.conf.file + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, wd.conf.file)
+}
+
+func (wd *webhookDispatcher) list() []subscriberView {
+	views := []subscriberView{}
+	if wd == nil {
+		return views
+	}
+	wd.Lock()
+	defer wd.Unlock()
+	for _, sub := range wd.subscriptions {
+		views = append(views, subscriberView{sub.ID, sub.URL, sub.Secret != "", sub.Filter, len(sub.queue)})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ID < views[j].ID })
+	return views
+}
+
+func (bn brightcoveNotifier) handleListSubscribers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, bn.subscribers.list())
+}
+
+// handlePutSubscriber registers a subscriber, or replaces it if the request has the id of an existing one.
+func (bn brightcoveNotifier) handlePutSubscriber(w http.ResponseWriter, r *http.Request) {
+	if bn.subscribers == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var s subscriber
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err == nil {
+		err = s.validate()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	status := http.StatusCreated
+	s.ID = uuid.NewRandom().String()
+	if id, found := mux.Vars(r)["id"]; found {
+		s.ID = id
+		status = http.StatusOK
+	}
+	wd := bn.subscribers
+	wd.Lock()
+	defer wd.Unlock()
+	if _, found := wd.subscriptions[s.ID]; status == http.StatusOK && !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	wd.subscribe(s)
+	if err := wd.save(); err != nil {
+		warnLogger.Printf("Could not save subscribers: [%v]", err)
+	}
+	infoLogger.Printf("subscriber=%s Subscriber registered for [%s].", s.ID, s.URL)
+	writeJSON(w, status, subscriberView{s.ID, s.URL, s.Secret != "", s.Filter, 0})
+}
+
+func (bn brightcoveNotifier) handleDeleteSubscriber(w http.ResponseWriter, r *http.Request) {
+	wd := bn.subscribers
+	if wd == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	wd.Lock()
+	defer wd.Unlock()
+	if !wd.unsubscribe(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := wd.save(); err != nil {
+		warnLogger.Printf("Could not save subscribers: [%v]", err)
+	}
+	infoLogger.Printf("subscriber=%s Subscriber removed.", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSubscriberDeliveries returns the delivery log of the subscriber, oldest first.
+func (bn brightcoveNotifier) handleSubscriberDeliveries(w http.ResponseWriter, r *http.Request) {
+	wd := bn.subscribers
+	if wd == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	wd.Lock()
+	sub, found := wd.subscriptions[mux.Vars(r)["id"]]
+	var log []webhookAttempt
+	if found {
+		log = append([]webhookAttempt{}, sub.log...)
+	}
+	wd.Unlock()
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, log)
+}
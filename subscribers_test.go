@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type subscriberRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newSubscriberStandIn answers with the given statuses in turn, then with 200, and passes the requests to the channel.
+func newSubscriberStandIn(statuses ...int) (*httptest.Server, chan subscriberRequest) {
+	requests := make(chan subscriberRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- subscriberRequest{r.Header, body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	return ts, requests
+}
+
+func newTestWebhookDispatcher(t *testing.T, file string) *webhookDispatcher {
+	wd, err := newWebhookDispatcher(webhookConfig{file: file, maxAttempts: 3, retryBackoff: time.Millisecond, queueSize: 10, logSize: 10},
+		http.DefaultClient.Do)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(wd.stop)
+	return wd
+}
+
+func receive(t *testing.T, requests chan subscriberRequest) subscriberRequest {
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("Expected a delivery to the subscriber.")
+		return subscriberRequest{}
+	}
+}
+
+func TestPublish_Subscribers_SignedPayloadDeliveredToMatchingOnly(t *testing.T) {
+	accID := "775205503001"
+	videoID := "4020894387001"
+	forwards := 0
+	matching, matchingRequests := newSubscriberStandIn()
+	defer matching.Close()
+	other, otherRequests := newSubscriberStandIn()
+	defer other.Close()
+	bn := &brightcoveNotifier{client: &http.Client{}, subscribers: newTestWebhookDispatcher(t, "")}
+	bn.subscribers.subscribe(subscriber{ID: "matching", URL: matching.URL, Secret: "s3cr3t", Filter: subscriberFilter{States: []string{"ACTIVE"}}})
+	bn.subscribers.subscribe(subscriber{ID: "other", URL: other.URL, Filter: subscriberFilter{Tags: []string{"podcast"}}})
+	ts := newDryRunTestServer(bn, accID, videoID, &forwards)
+	defer ts.Close()
+
+	event := videoEvent{Video: videoID, Event: "video-change"}
+	_, err := bn.publish(context.Background(), event, "tid_test", publishOptions{}, newHistoryEntry("tid_test", event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := receive(t, matchingRequests)
+	if r.header.Get(signatureHeader) != sign("s3cr3t", r.body) {
+		t.Errorf("Expected body signed with the secret. Found signature: [%s]", r.header.Get(signatureHeader))
+	}
+	if r.header.Get("X-Request-Id") != "tid_test" || r.header.Get("X-Event-Type") != "video-change" {
+		t.Errorf("Unexpected headers: [%v]", r.header)
+	}
+	var payload video
+	if err := json.Unmarshal(r.body, &payload); err != nil || payload["uuid"] == nil {
+		t.Errorf("Expected payload with uuid. Found: [%s]", r.body)
+	}
+	select {
+	case <-otherRequests:
+		t.Error("Expected no delivery to the subscriber whose filter doesn't match.")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookDispatcher_SubscriberFails_RetriedAndLogged(t *testing.T) {
+	ts, requests := newSubscriberStandIn(http.StatusBadGateway, http.StatusServiceUnavailable)
+	defer ts.Close()
+	wd := newTestWebhookDispatcher(t, "")
+	wd.subscribe(subscriber{ID: "sub", URL: ts.URL})
+
+	wd.notify(videoEvent{}, video{"uuid": "u1"}, "tid_test")
+	for i := 0; i < 3; i++ {
+		receive(t, requests)
+	}
+	time.Sleep(20 * time.Millisecond)
+	wd.Lock()
+	log := wd.subscriptions["sub"].log
+	wd.Unlock()
+	expected := []string{webhookStatusRetrying, webhookStatusRetrying, webhookStatusDelivered}
+	if len(log) != len(expected) {
+		t.Fatalf("Expected [%d] logged attempts. Found: [%+v]", len(expected), log)
+	}
+	for i, a := range log {
+		if a.Status != expected[i] || a.Attempt != i+1 || a.UUID != "u1" {
+			t.Errorf("Unexpected attempt [%d]: [%+v]", i, a)
+		}
+	}
+}
+
+func TestWebhookDispatcher_Resubscribed_PreviousWorkerStopped(t *testing.T) {
+	previousRequests := make(chan struct{}, 10)
+	previous := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		previousRequests <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer previous.Close()
+	ts, requests := newSubscriberStandIn()
+	defer ts.Close()
+	wd := newTestWebhookDispatcher(t, "")
+	wd.subscribe(subscriber{ID: "sub", URL: previous.URL})
+	wd.notify(videoEvent{}, video{"uuid": "u1"}, "tid_test")
+	<-previousRequests
+
+	wd.Lock()
+	wd.subscribe(subscriber{ID: "sub", URL: ts.URL})
+	wd.Unlock()
+	wd.notify(videoEvent{}, video{"uuid": "u2"}, "tid_test")
+	receive(t, requests)
+	time.Sleep(50 * time.Millisecond)
+
+	wd.Lock()
+	log := wd.subscriptions["sub"].log
+	wd.Unlock()
+	if len(log) != 1 || log[0].UUID != "u2" || log[0].Status != webhookStatusDelivered {
+		t.Errorf("Expected only the delivery of the new subscriber logged. Found: [%+v]", log)
+	}
+	select {
+	case <-previousRequests:
+		t.Error("Expected the previous worker not to retry.")
+	default:
+	}
+}
+
+func TestSubscriberFilter_Matches(t *testing.T) {
+	v := video{"state": "ACTIVE", "tags": []interface{}{"news", "video"}}
+	tests := []struct {
+		filter   subscriberFilter
+		expected bool
+	}{
+		{subscriberFilter{}, true},
+		{subscriberFilter{Events: []string{"video-change"}, States: []string{"ACTIVE"}}, true},
+		{subscriberFilter{Tags: []string{"podcast", "news"}}, true},
+		{subscriberFilter{Tags: []string{"podcast"}}, false},
+		{subscriberFilter{States: []string{"INACTIVE"}}, false},
+		{subscriberFilter{Events: []string{"other"}}, false},
+	}
+	for _, test := range tests {
+		if actual := test.filter.matches(videoEvent{Event: "video-change"}, v); actual != test.expected {
+			t.Errorf("Filter [%+v]: expected [%t]. Found: [%t]", test.filter, test.expected, actual)
+		}
+	}
+}
+
+func TestSubscribersAPI_RegisterListDelete_PersistedWithoutExposingSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscribers.json")
+	bn := &brightcoveNotifier{subscribers: newTestWebhookDispatcher(t, file)}
+	ts := httptest.NewServer(bn.router())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/__subscribers", "application/json", strings.NewReader(`{"url": "http://example.com/hook", "secret": "s3cr3t"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created subscriberView
+	_ = json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated || created.ID == "" || !created.Signed {
+		t.Fatalf("Expected subscriber created. Found: [%d] [%+v]", res.StatusCode, created)
+	}
+
+	res, err = http.Get(ts.URL + "/__subscribers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), created.ID) || strings.Contains(string(body), "s3cr3t") {
+		t.Errorf("Expected subscriber listed without its secret. Found: [%s]", body)
+	}
+
+	reloaded := newTestWebhookDispatcher(t, file)
+	if s, found := reloaded.subscriptions[created.ID]; !found || s.Secret != "s3cr3t" {
+		t.Errorf("Expected subscriber loaded from the file. Found: [%v]", reloaded.list())
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/__subscribers/"+created.ID, nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent || len(bn.subscribers.list()) != 0 {
+		t.Errorf("Expected subscriber deleted. Found: [%d] [%v]", res.StatusCode, bn.subscribers.list())
+	}
+}
+
+func TestSubscribersAPI_InvalidURL_BadRequest(t *testing.T) {
+	bn := &brightcoveNotifier{subscribers: newTestWebhookDispatcher(t, "")}
+	ts := httptest.NewServer(bn.router())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/__subscribers", "application/json", strings.NewReader(`{"url": "ftp://example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400. Found: [%d]", res.StatusCode)
+	}
+}
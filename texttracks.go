@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTextTrackSize is the size of the WebVTT files read, the rest is ignored.
+const maxTextTrackSize = 5 << 20
+
+// caption is an entry of the captions field added to the payload.
+type caption struct {
+	Language string `json:"language"`
+	Label    string `json:"label,omitempty"`
+	Kind     string `json:"kind"`
+	URL      string `json:"url"`
+	Default  bool   `json:"default"`
+}
+
+type cue struct {
+	start time.Duration
+	end   time.Duration
+	text  string
+}
+
+// addTextTracks fetches and validates the WebVTT text tracks of the video, and adds the valid ones as captions,
+// with the transcript of the default track, or of the first one. Invalid tracks are left out with a warning.
+func (bn brightcoveNotifier) addTextTracks(ctx context.Context, video video) error {
+	if video["error_code"] != nil {
+		return nil
+	}
+	tracks, _ := video["text_tracks"].([]interface{})
+	captions := []caption{}
+	var transcript string
+	for i, t := range tracks {
+		track, _ := t.(map[string]interface{})
+		c, err := textTrackCaption(track)
+		if err != nil {
+			warn(ctx, "Text track [%d] skipped: %v", i, err)
+			continue
+		}
+		if lang, ok := normaliseLanguage(c.Language); ok {
+			c.Language = lang
+		} else {
+			warn(ctx, "Text track [%d] has an invalid language code: [%s]", i, c.Language)
+		}
+		cues, err := bn.fetchTextTrack(ctx, c.URL)
+		if err != nil {
+			warn(ctx, "Text track [%d] [%s] skipped: %v", i, c.URL, err)
+			continue
+		}
+		captions = append(captions, c)
+		if transcript == "" || c.Default {
+			transcript = cueTranscript(cues)
+		}
+	}
+	video["captions"] = captions
+	if transcript != "" {
+		video["transcript"] = transcript
+	}
+	return nil
+}
+
+func textTrackCaption(track map[string]interface{}) (caption, error) {
+	c := caption{Kind: "captions"}
+	c.URL, _ = track["src"].(string)
+	sources, _ := track["sources"].([]interface{})
+	for _, s := range sources {
+		source, _ := s.(map[string]interface{})
+		if src, _ := source["src"].(string); isHTTPS(src) {
+			c.URL = src
+			break
+		}
+	}
+	if c.URL == "" {
+		return c, fmt.Errorf("no src")
+	}
+	if mime, _ := track["mime_type"].(string); mime != "" && mime != "text/vtt" && mime != "text/webvtt" {
+		return c, fmt.Errorf("unsupported mime type [%s]", mime)
+	}
+	c.Language, _ = track["srclang"].(string)
+	c.Label, _ = track["label"].(string)
+	if kind, _ := track["kind"].(string); kind != "" {
+		c.Kind = kind
+	}
+	c.Default, _ = track["default"].(bool)
+	return c, nil
+}
+
+func (bn brightcoveNotifier) fetchTextTrack(ctx context.Context, src string) ([]cue, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bn.do(upstreamTextTracks, req)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupResp(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Invalid statusCode received: [%d]", resp.StatusCode)
+	}
+	return parseWebVTT(io.LimitReader(resp.Body, maxTextTrackSize))
+}
+
+var cueTimestamp = regexp.MustCompile(`^(?:(\d{2,}):)?([0-5]\d):([0-5]\d)\.(\d{3})$`)
+
+func parseTimestamp(s string) (time.Duration, error) {
+	m := cueTimestamp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp [%s]", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second, time.Millisecond} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(m[i+1])
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// parseWebVTT returns the cues of the WebVTT file, or the first error found with its line number.
+func parseWebVTT(r io.Reader) ([]cue, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxTextTrackSize)
+	line := 0
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		line++
+		return strings.TrimRight(scanner.Text(), "\r"), true
+	}
+	header, _ := next()
+	header = strings.TrimPrefix(header, "\ufeff")
+	if header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+	var cues []cue
+	var current *cue
+	for {
+		text, ok := next()
+		if !ok {
+			break
+		}
+		switch {
+		case text == "":
+			current = nil
+		case current != nil:
+			if current.text != "" {
+				current.text += "\n"
+			}
+			current.text += text
+		case strings.Contains(text, "-->"):
+			fields := strings.Fields(text)
+			if len(fields) < 3 || fields[1] != "-->" {
+				return nil, fmt.Errorf("line %d: invalid cue timings [%s]", line, text)
+			}
+			start, err := parseTimestamp(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			end, err := parseTimestamp(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			if end < start {
+				return nil, fmt.Errorf("line %d: cue ends before it starts", line)
+			}
+			cues = append(cues, cue{start: start, end: end})
+			current = &cues[len(cues)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no cue")
+	}
+	return cues, nil
+}
+
+var cueTags = regexp.MustCompile(`<[^>]*>`)
+
+// cueTranscript joins the text of the cues without their markup, skipping the lines repeated by consecutive cues.
+func cueTranscript(cues []cue) string {
+	var lines []string
+	for _, c := range cues {
+		for _, l := range strings.Split(c.text, "\n") {
+			l = strings.TrimSpace(html.UnescapeString(cueTags.ReplaceAllString(l, "")))
+			if l == "" || (len(lines) > 0 && lines[len(lines)-1] == l) {
+				continue
+			}
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// iso6392 maps the ISO 639-2 codes of common languages to their ISO 639-1 codes.
+var iso6392 = map[string]string{
+	"ara": "ar", "chi": "zh", "zho": "zh", "deu": "de", "ger": "de", "dut": "nl", "nld": "nl", "eng": "en", "fra": "fr", "fre": "fr",
+	"hin": "hi", "ita": "it", "jpn": "ja", "kor": "ko", "por": "pt", "rus": "ru", "spa": "es",
+}
+
+var (
+	languageSubtag = regexp.MustCompile(`^[a-z]{2,3}$`)
+	scriptSubtag   = regexp.MustCompile(`^[A-Za-z]{4}$`)
+	regionSubtag   = regexp.MustCompile(`^([A-Za-z]{2}|\d{3})$`)
+)
+
+// normaliseLanguage turns the language code into a BCP 47 tag with the usual casing, e.g. EN_us to en-US or eng to en.
+// It returns false if the code is not a language, optionally followed by a script and a region.
+func normaliseLanguage(code string) (string, bool) {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(code), "_", "-", -1), "-")
+	lang := strings.ToLower(parts[0])
+	if short, found := iso6392[lang]; found {
+		lang = short
+	}
+	if !languageSubtag.MatchString(lang) || len(parts) > 3 {
+		return code, false
+	}
+	tag := []string{lang}
+	rest := parts[1:]
+	if len(rest) > 0 && scriptSubtag.MatchString(rest[0]) {
+		tag = append(tag, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && regionSubtag.MatchString(rest[0]) {
+		tag = append(tag, strings.ToUpper(rest[0]))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return code, false
+	}
+	return strings.Join(tag, "-"), true
+}
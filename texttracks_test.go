@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebVTT = "\ufeffWEBVTT - captions\r\n\r\nNOTE made by hand\r\n\r\n1\r\n00:00.000 --> 00:02.500 align:start\r\n<v Narrator>Sea &amp; marvels</v>\r\n\r\n" +
+	"00:00:02.500 --> 00:00:04.000\r\nSea &amp; marvels\r\nof the deep\r\n"
+
+func TestParseWebVTT(t *testing.T) {
+	cues, err := parseWebVTT(strings.NewReader(testWebVTT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 || cues[0].end != 2500*time.Millisecond || cues[1].start != 2500*time.Millisecond {
+		t.Fatalf("Unexpected cues: [%+v]", cues)
+	}
+	if transcript := cueTranscript(cues); transcript != "Sea & marvels of the deep" {
+		t.Errorf("Unexpected transcript: [%s]", transcript)
+	}
+
+	invalid := map[string]string{
+		"no header":        "00:00.000 --> 00:01.000\nHello\n",
+		"no cue":           "WEBVTT\n\nNOTE nothing\n",
+		"bad timestamp":    "WEBVTT\n\n00:00.000 --> 0:1.000\nHello\n",
+		"end before start": "WEBVTT\n\n00:02.000 --> 00:01.000\nHello\n",
+	}
+	for name, vtt := range invalid {
+		if _, err := parseWebVTT(strings.NewReader(vtt)); err == nil {
+			t.Errorf("Expected error for [%s].", name)
+		}
+	}
+}
+
+func TestNormaliseLanguage(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+		valid    bool
+	}{
+		{"en", "en", true},
+		{"EN_us", "en-US", true},
+		{"eng", "en", true},
+		{"zh-hant-tw", "zh-Hant-TW", true},
+		{"es-419", "es-419", true},
+		{"english", "english", false},
+		{"en-US-x", "en-US-x", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		actual, valid := normaliseLanguage(test.code)
+		if actual != test.expected || valid != test.valid {
+			t.Errorf("Language [%s]: expected [%s] [%t]. Found: [%s] [%t]", test.code, test.expected, test.valid, actual, valid)
+		}
+	}
+}
+
+func TestAddTextTracks_InvalidTrack_WarnedAndLeftOut(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fr.vtt":
+			_, _ = w.Write([]byte(testWebVTT))
+		case "/en.vtt":
+			_, _ = w.Write([]byte("WEBVTT\n\n00:00.000 --> 00:01.000\nSea and marvels\n"))
+		case "/broken.vtt":
+			_, _ = w.Write([]byte("<html>Not found</html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	bn := brightcoveNotifier{client: &http.Client{}, textTracks: true}
+	v := video{"id": "4020894387001", "text_tracks": []interface{}{
+		map[string]interface{}{"src": ts.URL + "/fr.vtt", "srclang": "fra", "kind": "subtitles", "mime_type": "text/webvtt"},
+		map[string]interface{}{"src": ts.URL + "/broken.vtt", "srclang": "de"},
+		map[string]interface{}{"src": ts.URL + "/en.vtt", "srclang": "EN_gb", "label": "English", "default": true},
+		map[string]interface{}{"srclang": "it"},
+	}}
+
+	ctx, warnings := withWarnings(context.Background())
+	if err := bn.addTextTracks(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	captions, _ := v["captions"].([]caption)
+	if len(captions) != 2 || captions[0].Language != "fr" || captions[0].Kind != "subtitles" || captions[1] != (caption{"en-GB", "English", "captions", ts.URL + "/en.vtt", true}) {
+		t.Errorf("Expected the valid tracks as captions. Found: [%+v]", captions)
+	}
+	if v["transcript"] != "Sea and marvels" {
+		t.Errorf("Expected transcript of the default track. Found: [%v]", v["transcript"])
+	}
+	if list := warnings.list(); len(list) != 2 || !strings.Contains(list[0], "missing WEBVTT header") || !strings.Contains(list[1], "no src") {
+		t.Errorf("Expected warnings for the invalid tracks. Found: %v", list)
+	}
+}
+
+func TestAddTextTracks_DeletedVideo_LeftUnchanged(t *testing.T) {
+	bn := brightcoveNotifier{client: &http.Client{}, textTracks: true}
+	v := video{"error_code": "RESOURCE_NOT_FOUND", "message": "The resource you requested does not exist", "id": "4020894387001"}
+	if err := bn.addTextTracks(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := v["captions"]; found || len(v) != 3 {
+		t.Errorf("Expected no captions added to the deleted video. Found: [%v]", v)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/Financial-Times/brightcove-notifier"
+
+	tracingExporterNone   = "none"
+	tracingExporterStdout = "stdout"
+	tracingExporterFile   = "file"
+	tracingExporterOTLP   = "otlp"
+)
+
+type tracingConfig struct {
+	exporter string
+	//file the spans are written to by the file exporter
+	file string
+	//host:port of the OTLP HTTP collector
+	otlpEndpoint string
+}
+
+func (tc tracingConfig) prettyPrint() string {
+	return fmt.Sprintf("\n\t\texporter: [%s]\n\t\tfile: [%s]\n\t\totlpEndpoint: [%s]\n\t", tc.exporter, tc.file, tc.otlpEndpoint)
+}
+
+// initTracing sets up the global tracer provider with the configured exporter, and the W3C trace context propagation.
+// The returned function flushes and stops the exporter, and closes the file of the file exporter.
+func initTracing(conf tracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch conf.exporter {
+	case tracingExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case tracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case tracingExporterFile:
+		file, err = os.OpenFile(conf.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case tracingExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(conf.otlpEndpoint), otlptracehttp.WithInsecure())
+	default:
+		return nil, fmt.Errorf("Unknown tracing exporter: [%s]", conf.exporter)
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "brightcove-notifier"))),
+	)
+	otel.SetTracerProvider(tp)
+	if file == nil {
+		return tp.Shutdown, nil
+	}
+	return func(ctx context.Context) error {
+		//the file is closed once the provider flushed the spans to it
+		return errors.Join(tp.Shutdown(ctx), file.Close())
+	}, nil
+}
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startRequestSpan starts the span of an incoming request, continuing the trace of the caller if it sent a traceparent header.
+func startRequestSpan(r *http.Request, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return startSpan(ctx, name, attrs...)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the traceparent header of the current span to the outgoing request.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+func videoIDAttr(id interface{}) attribute.KeyValue {
+	return attribute.String("video_id", fmt.Sprintf("%v", id))
+}
+
+func uuidAttr(uuid interface{}) attribute.KeyValue {
+	return attribute.String("uuid", fmt.Sprintf("%v", uuid))
+}
+
+func accountIDAttr(id string) attribute.KeyValue {
+	return attribute.String("account_id", id)
+}
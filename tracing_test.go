@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHandleNotification_Tracing_SpansAreRecordedAndTraceparentReachesCMSNotifier(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previous)
+
+	accID := "775205503001"
+	videoID := "4020894387001"
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/accounts/%s/videos/%s", accID, videoID):
+			_, _ = w.Write([]byte(buildTestVideoModel(accID, videoID)))
+		case "/cms-notifier/notify":
+			traceparent = r.Header.Get("traceparent")
+		}
+	}))
+	defer ts.Close()
+	bn := &brightcoveNotifier{
+		client:          &http.Client{},
+		brightcoveConf:  &brightcoveConfig{addr: ts.URL + "/accounts/", accountID: accID},
+		cmsNotifierConf: &cmsNotifierConfig{addr: ts.URL + "/cms-notifier"},
+	}
+
+	bn.handleNotification(httptest.NewRecorder(), httptest.NewRequest("POST", "/notify", strings.NewReader(buildTestVideoEvent(accID, videoID))))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	for _, name := range []string{"handleNotification", "fetchVideo", "transform/upp_required_fields", "fwdVideo"} {
+		if _, found := spans[name]; !found {
+			t.Fatalf("Expected span [%s]. Recorded: [%v]", name, spans)
+		}
+	}
+	root := spans["handleNotification"]
+	attrs := make(map[string]string)
+	for _, a := range root.Attributes() {
+		attrs[string(a.Key)] = a.Value.AsString()
+	}
+	if attrs["video_id"] != videoID || attrs["account_id"] != accID || attrs["uuid"] == "" {
+		t.Fatalf("Expected video_id, account_id and uuid attributes. Found: [%v]", attrs)
+	}
+	if !strings.Contains(traceparent, root.SpanContext().TraceID().String()) {
+		t.Fatalf("Expected traceparent of trace [%s] to reach CMS Notifier. Found: [%s]", root.SpanContext().TraceID(), traceparent)
+	}
+}
+
+func TestInitTracing_FileExporterShutDown_SpansFlushedAndFileClosed(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := initTracing(tracingConfig{exporter: tracingExporterFile, file: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := startSpan(context.Background(), "fetchVideo")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected shutdown to flush and close the file. Found: [%v]", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || !strings.Contains(string(data), `"Name":"fetchVideo"`) {
+		t.Errorf("Expected the span written to the file. Found: [%s] [%v]", data, err)
+	}
+	if err := shutdown(context.Background()); err == nil {
+		t.Error("Expected the file already closed.")
+	}
+}
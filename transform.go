@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+)
+
+// transformation is a named step turning the fetched Brightcove video into the UPP payload.
+type transformation struct {
+	name      string
+	transform func(ctx context.Context, video video) error
+}
+
+func (bn brightcoveNotifier) transformations() []transformation {
+	ts := []transformation{
+		{"upp_required_fields", func(_ context.Context, video video) error { return addUPPRequiredFields(video) }},
+	}
+	if bn.imageSets {
+		ts = append(ts, transformation{"images", addMainImage})
+	}
+	if bn.textTracks {
+		ts = append(ts, transformation{"text_tracks", bn.addTextTracks})
+	}
+	if bn.playlists.enabled() {
+		ts = append(ts, transformation{"playlists", bn.addPlaylists})
+	}
+	return ts
+}
+
+// transform applies the transformations in order and returns the names of the ones that ran.
+func (bn brightcoveNotifier) transform(ctx context.Context, video video) ([]string, error) {
+	ran := []string{}
+	for _, t := range bn.transformations() {
+		tCtx, span := startSpan(ctx, "transform/"+t.name, videoIDAttr(video["id"]))
+		err := t.transform(tCtx, video)
+		endSpan(span, err)
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, t.name)
+	}
+	return ran, nil
+}